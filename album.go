@@ -0,0 +1,187 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// DefaultAlbumWindow 相册消息的默认等待时间
+const DefaultAlbumWindow = 500 * time.Millisecond
+
+// AlbumContext 相册（媒体组）上下文，同一相册中的消息合并为一次处理
+type AlbumContext struct {
+	MessageContextBase // 相册中第一条消息
+
+	MediaGroupID string              // 媒体组 ID
+	Messages     []*telegram.Message // 相册中的消息，按消息 ID 排序
+	Caption      string              // 相册的说明（第一条带说明的消息的说明）
+}
+
+// AlbumProcessorFunc 相册处理函数
+type AlbumProcessorFunc func(c *AlbumContext) error
+
+// SetAlbumProcessor 设置相册处理器。设置后带有 MediaGroupID 的消息不再单独处理，
+// 同一相册的消息在 window 内没有新消息后合并交给处理器（window 为0时使用 DefaultAlbumWindow）。
+// 相册等待期间收到串行键相同的其他更新时，相册立即提交，以保持与其他更新的顺序（之后到达的相册消息作为新的相册）。
+// 长轮询与 webhook 接收的消息都会合并，Shutdown 时尚未结束等待的相册会立即处理，之后接收的相册消息不再合并
+func (b *Bot) SetAlbumProcessor(fn AlbumProcessorFunc, window time.Duration) {
+	if window <= 0 {
+		window = DefaultAlbumWindow
+	}
+	b.albums.fn = fn
+	b.albums.window = window
+}
+
+// albumBuffer 正在等待的相册
+type albumBuffer struct {
+	key     string // 串行键
+	updates []*telegram.Update
+	timer   *time.Timer
+}
+
+// albumCollector 相册收集器
+type albumCollector struct {
+	fn     AlbumProcessorFunc
+	window time.Duration
+
+	mu      sync.Mutex              // 提交相册时同样持有，保证相册先于之后接收的同一串行键的更新提交
+	pending map[string]*albumBuffer // 键为会话 ID 与媒体组 ID
+	closed  bool                    // 调度器即将关闭，不再收集
+}
+
+// collectAlbum 收集相册中的消息，返回消息是否已被收集
+// 不是相册消息时先提交串行键相同的正在等待的相册
+func (b *Bot) collectAlbum(update *telegram.Update) bool {
+	a := &b.albums
+	if a.fn == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return false
+	}
+	if update.Message == nil || update.Message.MediaGroupID == "" {
+		if len(a.pending) == 0 {
+			return false
+		}
+		if serialKey := b.safeKeyFunc(update); serialKey != "" {
+			for key, buf := range a.pending {
+				if buf.key == serialKey {
+					b.submitAlbum(key)
+				}
+			}
+		}
+		return false
+	}
+
+	key := update.Message.MediaGroupID
+	if update.Message.Chat != nil {
+		key = fmt.Sprintf("%d:%s", update.Message.Chat.ID, key)
+	}
+
+	if a.pending == nil {
+		a.pending = map[string]*albumBuffer{}
+	}
+	buf, ok := a.pending[key]
+	if !ok {
+		buf = &albumBuffer{key: b.safeKeyFunc(update)}
+		buf.timer = time.AfterFunc(a.window, func() {
+			b.flushAlbum(key)
+		})
+		a.pending[key] = buf
+	} else {
+		buf.timer.Reset(a.window)
+	}
+	buf.updates = append(buf.updates, update)
+	return true
+}
+
+// flushAlbum 将相册提交给调度器
+func (b *Bot) flushAlbum(key string) {
+	a := &b.albums
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b.submitAlbum(key)
+}
+
+// flushAlbums 立即提交所有正在等待的相册并停止收集，在调度器关闭前调用
+func (b *Bot) flushAlbums() {
+	a := &b.albums
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	for key := range a.pending {
+		b.submitAlbum(key)
+	}
+}
+
+// reopenAlbums 调度器创建后重新开始收集
+func (b *Bot) reopenAlbums() {
+	a := &b.albums
+	a.mu.Lock()
+	a.closed = false
+	a.mu.Unlock()
+}
+
+// submitAlbum 将相册提交给调度器，调用时必须持有 albums.mu
+func (b *Bot) submitAlbum(key string) {
+	a := &b.albums
+	buf, ok := a.pending[key]
+	if !ok {
+		return
+	}
+	delete(a.pending, key)
+
+	buf.timer.Stop()
+	if err := b.dispatchAlbum(buf.updates); err != nil {
+		b.handleUpdateError(buf.updates[0], fmt.Errorf("album: %w", err))
+	}
+}
+
+// dispatchAlbum 将相册作为一个任务提交给调度器，与相册中第一条消息在同一队列中处理
+func (b *Bot) dispatchAlbum(updates []*telegram.Update) error {
+	b.mu.Lock()
+	d := b.dispatcher
+	b.mu.Unlock()
+	if d == nil {
+		return ErrBotClosed
+	}
+
+	return d.submit(context.Background(), &job{update: updates[0], album: updates})
+}
+
+// handleAlbum 处理相册
+func (b *Bot) handleAlbum(updates []*telegram.Update) {
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].Message.MessageID < updates[j].Message.MessageID
+	})
+
+	ctx := b.newContext(updates[0])
+	album := &AlbumContext{
+		MessageContextBase: newMessageContextBase(ctx),
+		MediaGroupID:       updates[0].Message.MediaGroupID,
+	}
+	for _, update := range updates {
+		album.Messages = append(album.Messages, update.Message)
+		if album.Caption == "" {
+			album.Caption = update.Message.Caption
+		}
+	}
+
+	fn := b.albums.fn
+	b.runContext(ctx, func() {
+		if err := b.invoke(ctx, func(c *Context) error { return fn(album) }); err != nil && !errors.Is(err, ErrContinue) {
+			b.handleUpdateError(ctx.Update, fmt.Errorf("SetAlbumProcessor: %w", err))
+		}
+	})
+}
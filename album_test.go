@@ -0,0 +1,116 @@
+package tgbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func albumUpdates() []telegram.Update {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtPrivate}
+	photo := func(id int64, group string, caption string) telegram.Update {
+		return telegram.Update{UpdateID: id, Message: &telegram.Message{MessageID: id, Chat: chat, MediaGroupID: group, Caption: caption, Photo: []telegram.PhotoSize{{FileID: fmt.Sprint("p", id)}}}}
+	}
+	return []telegram.Update{
+		photo(2, "g1", ""),
+		photo(1, "g1", "holiday"),
+		photo(3, "g1", ""),
+		photo(4, "", "single"),
+	}
+}
+
+// registerAlbumRecorders 记录相册与单张照片
+func registerAlbumRecorders(b *Bot, ch chan<- string, window time.Duration) {
+	b.SetAlbumProcessor(func(c *AlbumContext) error {
+		s := c.MediaGroupID + ":" + c.Caption
+		for _, m := range c.Messages {
+			s += fmt.Sprint(":", m.MessageID)
+		}
+		ch <- s
+		return nil
+	}, window)
+	b.SetMessageProcessorAtPhoto(func(c *MessageContextAtPhoto) error {
+		ch <- "photo:" + c.Caption
+		return nil
+	})
+}
+
+func TestBot_Album(t *testing.T) {
+	// 单张照片在相册之后接收，相册立即提交以保持顺序
+	want := []string{"g1:holiday:1:2:3", "photo:single"}
+
+	// 长轮询
+	polling := make(chan string, 8)
+	f := newFakeTelegram(t, albumUpdates()...)
+	b := f.newBot(nil)
+	registerAlbumRecorders(b, polling, 50*time.Millisecond)
+	runBot(t, b)
+	if result := collect(t, polling, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("长轮询处理结果 %v 与预期 %v 不一致", result, want)
+	}
+
+	// webhook，只推送相册，等待时间较长，由 Shutdown 立即处理
+	webhook := make(chan string, 8)
+	wb := newFakeTelegram(t).newBot(nil)
+	registerAlbumRecorders(wb, webhook, time.Hour)
+	if err := wb.SetWebhook("https://example.com/bot", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- wb.Run()
+	}()
+	waitRunning(t, wb)
+
+	server := httptest.NewServer(wb.WebhookHandler())
+	defer server.Close()
+	post := func(update telegram.Update) int {
+		body, err := json.Marshal(update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	updates := albumUpdates()
+	for _, update := range updates[:3] {
+		if status := post(update); status != http.StatusOK {
+			t.Fatalf("webhook 响应 %d", status)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case s := <-webhook:
+		t.Fatalf("相册在等待结束前不应处理: %s", s)
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wb.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-runErr; err != ErrBotClosed {
+		t.Fatal(err)
+	}
+	if result := collect(t, webhook, 1); result[0] != want[0] {
+		t.Fatalf("Shutdown 时相册处理结果 %v 与预期 %v 不一致", result, want[0])
+	}
+
+	// 关闭后接收的相册消息不再合并，返回 503 由 telegram 重新投递
+	if status := post(updates[0]); status != http.StatusServiceUnavailable {
+		t.Fatalf("关闭后 webhook 响应 %d", status)
+	}
+}
@@ -0,0 +1,360 @@
+package tgbot
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// MaxCallbackDataLength 回调数据的最大长度（字节）
+const MaxCallbackDataLength = 64
+
+const (
+	callbackSeparator = ':' // 前缀与字段、字段与字段之间的分隔符
+	callbackStoreMark = '#' // 前缀与服务端存储 ID 之间的分隔符
+	callbackEscape    = '\\'
+)
+
+var (
+	// ErrCallbackDataTooLong 回调数据超过 64 字节且没有设置服务端存储
+	ErrCallbackDataTooLong = errors.New("tgbot: callback data exceeds 64 bytes")
+	// ErrCallbackExpired 回调数据保存在服务端存储中，但已过期或不存在
+	ErrCallbackExpired = errors.New("tgbot: callback data expired")
+)
+
+// callbackRoute 回调路由
+type callbackRoute struct {
+	prefix  string         // 按前缀匹配
+	pattern *regexp.Regexp // 按正则匹配（prefix 为空时）
+	fn      MessageProcessorFunc
+}
+
+// AddCallback 添加按前缀匹配的回调查询处理器
+// 回调数据由 CallbackData 生成，格式为 prefix、prefix:字段:字段 或 prefix#存储ID，前缀不能包含 : 与 #。
+// 路由按添加顺序匹配，都不匹配时交给 SetCallbackQueryProcessor 设置的处理器
+func (b *Bot) AddCallback(prefix string, fn CallbackQueryProcessorFunc) {
+	b.addCallbackRoute(&callbackRoute{prefix: prefix, fn: callbackProcessor(fn)})
+}
+
+// AddCallbackPattern 添加按正则匹配完整回调数据的回调查询处理器，子匹配可以通过 CallbackQueryContext.Matches 获取
+func (b *Bot) AddCallbackPattern(pattern *regexp.Regexp, fn CallbackQueryProcessorFunc) {
+	b.addCallbackRoute(&callbackRoute{pattern: pattern, fn: callbackProcessor(fn)})
+}
+
+// addCallbackRoute 添加回调路由
+func (b *Bot) addCallbackRoute(route *callbackRoute) {
+	b.callbacks = append(b.callbacks, route)
+}
+
+// callbackProcessor 将回调查询处理器转换为通用处理器
+func callbackProcessor(fn CallbackQueryProcessorFunc) MessageProcessorFunc {
+	return func(c *Context) error {
+		return fn(&CallbackQueryContext{API: c.API, CallbackQuery: c.Update.CallbackQuery, ctx: c})
+	}
+}
+
+// SetCallbackStore 设置回调数据的服务端存储，CallbackData 生成的数据超过 64 字节时将字段保存在存储中，
+// 按钮中只保留前缀与存储 ID。ttl 为数据的有效期，为0时不过期
+func (b *Bot) SetCallbackStore(store SessionStore, ttl time.Duration) {
+	b.callbackStore = store
+	b.callbackStoreTTL = ttl
+}
+
+// CallbackData 生成回调数据（用于 telegram.InlineKeyboardButton.CallbackData）
+// payload 可以是 nil、结构体（按顺序编码导出的字段）或字符串、整数、浮点数、布尔值等基本类型，
+// 解码时使用相同的类型调用 CallbackQueryContext.Payload
+func (b *Bot) CallbackData(prefix string, payload interface{}) (string, error) {
+	if prefix == "" || strings.ContainsAny(prefix, string(callbackSeparator)+string(callbackStoreMark)) {
+		return "", fmt.Errorf("tgbot: invalid callback prefix %q", prefix)
+	}
+
+	fields, err := encodeCallbackFields(payload)
+	if err != nil {
+		return "", err
+	}
+	data := prefix
+	if fields != "" {
+		data += string(callbackSeparator) + fields
+	}
+	if len(data) <= MaxCallbackDataLength {
+		return data, nil
+	}
+
+	if b.callbackStore == nil {
+		return "", ErrCallbackDataTooLong
+	}
+	id, err := newCallbackID()
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	if err := b.callbackStore.Set("callback:"+id, value, b.callbackStoreTTL); err != nil {
+		return "", err
+	}
+
+	data = prefix + string(callbackStoreMark) + id
+	if len(data) > MaxCallbackDataLength {
+		return "", ErrCallbackDataTooLong
+	}
+	return data, nil
+}
+
+// newCallbackID 生成服务端存储 ID
+func newCallbackID() (string, error) {
+	buf := make([]byte, 9)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// encodeCallbackFields 将 payload 编码为以 : 分隔的字段
+func encodeCallbackFields(payload interface{}) (string, error) {
+	if payload == nil {
+		return "", nil
+	}
+
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return formatCallbackField(v)
+	}
+
+	var fields []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" { // 未导出
+			continue
+		}
+		s, err := formatCallbackField(v.Field(i))
+		if err != nil {
+			return "", fmt.Errorf("callback field %s: %w", t.Field(i).Name, err)
+		}
+		fields = append(fields, s)
+	}
+	return strings.Join(fields, string(callbackSeparator)), nil
+}
+
+// formatCallbackField 编码单个字段，布尔值编码为 1 或 0
+func formatCallbackField(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		r := strings.NewReplacer(string(callbackEscape), `\\`, string(callbackSeparator), `\:`)
+		return r.Replace(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported field type %s", v.Type())
+}
+
+// splitCallbackFields 按未转义的 : 分割字段并去掉转义
+func splitCallbackFields(s string) []string {
+	var (
+		fields []string
+		cur    strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case callbackEscape:
+			if i+1 < len(s) {
+				i++
+			}
+			cur.WriteByte(s[i])
+		case callbackSeparator:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	return append(fields, cur.String())
+}
+
+// decodeCallbackFields 将字段解码到 ptr
+func decodeCallbackFields(s string, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("Payload: ptr must be a non-nil pointer")
+	}
+	v = v.Elem()
+	fields := splitCallbackFields(s)
+
+	if v.Kind() != reflect.Struct {
+		if len(fields) != 1 {
+			return fmt.Errorf("callback payload: expected 1 field, got %d", len(fields))
+		}
+		return setCallbackField(v, fields[0])
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("callback payload: missing field %s", t.Field(i).Name)
+		}
+		if err := setCallbackField(v.Field(i), fields[0]); err != nil {
+			return fmt.Errorf("callback field %s: %w", t.Field(i).Name, err)
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 0 {
+		return fmt.Errorf("callback payload: %d extra fields", len(fields))
+	}
+	return nil
+}
+
+// setCallbackField 解码单个字段
+func setCallbackField(fv reflect.Value, s string) error {
+	if fv.Kind() == reflect.Bool {
+		fv.SetBool(s == "1")
+		return nil
+	}
+	return setArg(fv, s)
+}
+
+// matchCallback 查找匹配的回调路由
+func (b *Bot) matchCallback(c *Context, data string) *callbackRoute {
+	head := data
+	if i := strings.IndexAny(data, string(callbackSeparator)+string(callbackStoreMark)); i != -1 {
+		head = data[:i]
+	}
+
+	for _, route := range b.callbacks {
+		if route.pattern == nil {
+			if route.prefix == head {
+				c.callbackData = data[len(head):]
+				return route
+			}
+			continue
+		}
+		if m := route.pattern.FindStringSubmatch(data); m != nil {
+			c.pattern, c.matches = route.pattern, m
+			return route
+		}
+	}
+	return nil
+}
+
+// handleCallbackQuery 将回调查询交给匹配的路由处理，路由返回 ErrContinue 时交给 SetCallbackQueryProcessor 设置的处理器
+func (b *Bot) handleCallbackQuery(c *Context) {
+	fn := b.updateProcessor(telegram.UpdateTypeAtCallbackQuery)
+	if route := b.matchCallback(c, c.Update.CallbackQuery.Data); route != nil {
+		err := b.invoke(c, route.fn)
+		if !errors.Is(err, ErrContinue) {
+			if err != nil {
+				b.handleUpdateError(c.Update, fmt.Errorf("%s processor: %w", telegram.UpdateTypeAtCallbackQuery, err))
+			}
+			return
+		}
+	}
+	if fn == nil {
+		return
+	}
+
+	if err := b.invoke(c, fn); err != nil && !errors.Is(err, ErrContinue) {
+		b.handleUpdateError(c.Update, fmt.Errorf("%s processor: %w", telegram.UpdateTypeAtCallbackQuery, err))
+	}
+}
+
+// autoAnswerCallbackQuery 回调查询处理结束后（包括交给会话处理的），处理器没有应答时自动调用 answerCallbackQuery
+func (b *Bot) autoAnswerCallbackQuery(c *Context) {
+	if c.Update.CallbackQuery == nil || c.callbackAnswered {
+		return
+	}
+	if err := c.Respond("answerCallbackQuery", map[string]interface{}{"callback_query_id": c.Update.CallbackQuery.ID}, nil); err != nil {
+		b.handleUpdateError(c.Update, fmt.Errorf("answer callback query: %w", err))
+	}
+}
+
+// isAnswerCallbackQuery 判断 API 方法是否为 answerCallbackQuery
+func isAnswerCallbackQuery(method string) bool {
+	return strings.EqualFold(strings.TrimPrefix(method, "/"), "answerCallbackQuery")
+}
+
+// CallbackData 见 Bot.CallbackData
+func (c *Context) CallbackData(prefix string, payload interface{}) (string, error) {
+	return c.bot.CallbackData(prefix, payload)
+}
+
+// CallbackData 见 Bot.CallbackData
+func (c *CallbackQueryContext) CallbackData(prefix string, payload interface{}) (string, error) {
+	return c.ctx.CallbackData(prefix, payload)
+}
+
+// Payload 将通过 AddCallback 匹配的回调数据解码到 ptr，ptr 的类型需要与生成回调数据时的 payload 一致
+func (c *CallbackQueryContext) Payload(ptr interface{}) error {
+	data := c.ctx.callbackData
+	if data == "" {
+		return nil
+	}
+
+	fields := data[1:]
+	if data[0] == callbackStoreMark {
+		if c.ctx.bot.callbackStore == nil {
+			return ErrCallbackExpired
+		}
+		value, err := c.ctx.bot.callbackStore.Get("callback:" + fields)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return ErrCallbackExpired
+		}
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return err
+		}
+	}
+	return decodeCallbackFields(fields, ptr)
+}
+
+// Matches 获取通过 AddCallbackPattern 匹配时的子匹配（第一个元素为完整的回调数据）
+func (c *CallbackQueryContext) Matches() []string {
+	return c.ctx.matches
+}
+
+// AnswerCallbackQuery 应答回调查询，应答当前回调查询后不再自动应答
+func (c *Context) AnswerCallbackQuery(callbackQueryID string, optional *telegram.AnswerCallbackQueryOptional) (bool, error) {
+	if c.Update != nil && c.Update.CallbackQuery != nil && callbackQueryID == c.Update.CallbackQuery.ID {
+		c.callbackAnswered = true
+	}
+	return c.API.AnswerCallbackQuery(callbackQueryID, optional)
+}
+
+// AnswerCallbackQuery 见 Context.AnswerCallbackQuery
+func (c *CallbackQueryContext) AnswerCallbackQuery(callbackQueryID string, optional *telegram.AnswerCallbackQueryOptional) (bool, error) {
+	return c.ctx.AnswerCallbackQuery(callbackQueryID, optional)
+}
+
+// Answer 应答当前回调查询，text 为空时不显示通知
+func (c *CallbackQueryContext) Answer(text string, showAlert bool) error {
+	return c.ctx.AnswerCallback(text, showAlert)
+}
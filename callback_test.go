@@ -0,0 +1,177 @@
+package tgbot
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+type votePayload struct {
+	PollID int64
+	Option string
+	Undo   bool
+}
+
+func TestBot_CallbackData(t *testing.T) {
+	b := New(1, "token", nil)
+
+	data, err := b.CallbackData("vote", votePayload{PollID: 42, Option: `a:b\c`, Undo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != `vote:42:a\:b\\c:1` {
+		t.Fatalf("编码结果错误: %s", data)
+	}
+
+	long := votePayload{PollID: 1, Option: strings.Repeat("x", 80)}
+	if _, err := b.CallbackData("vote", long); err != ErrCallbackDataTooLong {
+		t.Fatalf("超长数据应返回 ErrCallbackDataTooLong，实际 %v", err)
+	}
+
+	b.SetCallbackStore(NewMemorySessionStore(), time.Hour)
+	stored, err := b.CallbackData("vote", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) > MaxCallbackDataLength || !strings.HasPrefix(stored, "vote#") {
+		t.Fatalf("服务端存储的回调数据错误: %s", stored)
+	}
+
+	for in, want := range map[string]votePayload{data: {42, `a:b\c`, true}, stored: long} {
+		c := &Context{bot: b, callbackData: in[len("vote"):]}
+
+		var got votePayload
+		if err := (&CallbackQueryContext{ctx: c}).Payload(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("解码结果 %+v 与预期 %+v 不一致", got, want)
+		}
+	}
+}
+
+func TestBot_CallbackRouter(t *testing.T) {
+	query := func(id int64, data string) telegram.Update {
+		return telegram.Update{UpdateID: id, CallbackQuery: &telegram.CallbackQuery{ID: "q" + string(rune('0'+id)), From: &telegram.User{ID: 200}, Data: data}}
+	}
+	f := newFakeTelegram(t,
+		query(1, "vote:7:yes:0"),
+		query(2, "page-3"),
+		query(3, "unknown"),
+	)
+	b := f.newBot(nil)
+	got := make(chan string, 8)
+	b.AddCallback("vote", func(c *CallbackQueryContext) error {
+		var p votePayload
+		if err := c.Payload(&p); err != nil {
+			return err
+		}
+		got <- "vote:" + p.Option
+		return c.Answer("ok", false)
+	})
+	b.AddCallbackPattern(regexp.MustCompile(`^page-(\d+)$`), func(c *CallbackQueryContext) error {
+		got <- "page:" + c.Matches()[1]
+		return nil
+	})
+	b.SetCallbackQueryProcessor(func(c *CallbackQueryContext) error {
+		got <- "default:" + c.Data
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"vote:yes", "page:3", "default:unknown"}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Calls("answerCallbackQuery")) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("每个回调查询都应被应答一次: %v", f.Calls("answerCallbackQuery"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	calls := f.Calls("answerCallbackQuery")
+	if len(calls) != 3 {
+		t.Fatalf("已应答的回调查询不应再自动应答: %v", calls)
+	}
+	for _, call := range calls {
+		if strings.Contains(call.Body, `"q1"`) && !strings.Contains(call.Body, `"ok"`) {
+			t.Fatalf("处理器的应答内容错误: %s", call.Body)
+		}
+	}
+}
+
+func TestBot_CallbackConversation(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	user := &telegram.User{ID: 200}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, user, "/pick"),
+		telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{ID: "q2", From: user, Message: &telegram.Message{MessageID: 1, Chat: chat}, Data: "apple"}},
+	)
+	b := f.newBot(nil)
+	got := make(chan string, 4)
+	b.AddConversation("pick", nil).
+		Entry("/pick", func(c *Context) error {
+			return c.SetState("pick")
+		}).
+		State("pick", func(c *Context) error {
+			got <- "pick:" + c.Update.CallbackQuery.Data
+			return c.EndConversation()
+		})
+	b.SetCallbackQueryProcessor(func(c *CallbackQueryContext) error {
+		got <- "default:" + c.Data
+		return nil
+	})
+	runBot(t, b)
+
+	if result := collect(t, got, 1); result[0] != "pick:apple" {
+		t.Fatalf("回调查询应由会话处理: %v", result)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Calls("answerCallbackQuery")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("会话处理的回调查询应被自动应答")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := f.Calls("answerCallbackQuery"); len(calls) != 1 || !strings.Contains(calls[0].Body, `"q2"`) {
+		t.Fatalf("回调查询应只被应答一次: %v", calls)
+	}
+}
+
+func TestBot_CallbackConversationAnswered(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	user := &telegram.User{ID: 200}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, user, "/pick"),
+		telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{ID: "q2", From: user, Message: &telegram.Message{MessageID: 1, Chat: chat}, Data: "apple"}},
+	)
+	b := f.newBot(nil)
+	done := make(chan string, 1)
+	b.AddConversation("pick", nil).
+		Entry("/pick", func(c *Context) error {
+			return c.SetState("pick")
+		}).
+		State("pick", func(c *Context) error {
+			_, err := c.AnswerCallbackQuery(c.Update.CallbackQuery.ID, &telegram.AnswerCallbackQueryOptional{Text: "picked"})
+			done <- fmt.Sprint(err)
+			return c.EndConversation()
+		})
+	runBot(t, b)
+
+	if result := collect(t, done, 1); result[0] != "<nil>" {
+		t.Fatalf("应答回调查询失败: %v", result)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := f.Calls("answerCallbackQuery"); len(calls) != 1 || !strings.Contains(calls[0].Body, "picked") {
+		t.Fatalf("手动应答后不应再自动应答: %v", calls)
+	}
+}
@@ -0,0 +1,617 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// CommandOptional 命令可选参数
+type CommandOptional struct {
+	Aliases    []string // 别名，如 /start 的别名 /begin
+	IgnoreCase bool     // 忽略大小写，/Start 与 /START 均可匹配 /start
+	Usage      string   // 用法说明，参数错误（ArgsError）时附加在错误信息后回复给用户
+
+	Description  string            // 命令说明（3-256个字符），运行时同步到 telegram 的命令列表
+	Descriptions map[string]string // 按语言代码（如 "zh"）本地化的命令说明，未设置的语言使用 Description
+	Hidden       bool              // 不同步到 telegram 的命令列表
+}
+
+// command 已注册的命令
+type command struct {
+	name     string
+	fn       MessageProcessorFunc
+	optional CommandOptional
+}
+
+// AddCommand 添加命令处理器，cmd 可以带或不带前缀 /
+// 消息中的 /cmd@botusername 只有在 botusername 为当前 bot 时才会被处理
+func (b *Bot) AddCommand(cmd string, execFunc MessageProcessorFunc, optional *CommandOptional) {
+	b.addCommand(0, cmd, execFunc, optional)
+}
+
+// addCommand 添加指定优先级的命令处理器
+func (b *Bot) addCommand(priority int, cmd string, execFunc MessageProcessorFunc, optional *CommandOptional) {
+	c := &command{name: trimCommand(cmd), fn: execFunc}
+	if optional != nil {
+		c.optional = *optional
+	}
+
+	if b.commands == nil {
+		b.commands = map[string]*command{}
+	}
+	if old, ok := b.commands[c.name]; ok { // 重复注册时替换，保持原有顺序
+		for i := range b.commandList {
+			if b.commandList[i] == old {
+				b.commandList[i] = c
+			}
+		}
+		b.removeCommandNames(old)
+	} else {
+		b.commandList = append(b.commandList, c)
+	}
+	for _, name := range append([]string{c.name}, c.optional.Aliases...) {
+		name = trimCommand(name)
+		b.commands[name] = c
+		if c.optional.IgnoreCase {
+			if b.commandsFold == nil {
+				b.commandsFold = map[string]*command{}
+			}
+			b.commandsFold[strings.ToLower(name)] = c
+		}
+	}
+
+	b.messageHandlers.add(&handler{
+		key:      "command:" + c.name,
+		priority: priority,
+		stage:    handlerStageAtCommand,
+		match: func(ctx *Context) bool {
+			return ctx.command != "" && b.lookupCommand(ctx.command) == c
+		},
+		run: func(ctx *Context) error {
+			err := b.invokeCommand(ctx, c.fn)
+			if errors.Is(err, ErrContinue) {
+				return err
+			}
+			if err != nil && b.replyArgsError(ctx, c, err) {
+				return nil
+			}
+			return err
+		},
+	})
+}
+
+// removeCommandNames 删除命令的名称与别名（仍指向该命令的）
+func (b *Bot) removeCommandNames(c *command) {
+	for _, name := range append([]string{c.name}, c.optional.Aliases...) {
+		name = trimCommand(name)
+		if b.commands[name] == c {
+			delete(b.commands, name)
+		}
+		if b.commandsFold[strings.ToLower(name)] == c {
+			delete(b.commandsFold, strings.ToLower(name))
+		}
+	}
+}
+
+// invokeCommand 执行命令处理器
+// 兼容旧版本：处理器中的 Message.Text 为命令之后的文本（与 ArgsText 相同）。
+// 为此处理器使用消息的副本，Update 中的原始消息不会被修改，处理器返回后 Message 恢复为原始消息
+func (b *Bot) invokeCommand(ctx *Context, fn MessageProcessorFunc) error {
+	message := *ctx.Message
+	message.Text = ctx.argsText
+	original := ctx.Message
+	ctx.Message = &message
+	defer func() {
+		ctx.Message = original
+	}()
+
+	return b.invoke(ctx, fn)
+}
+
+// trimCommand 去除命令前缀 /
+func trimCommand(cmd string) string {
+	return strings.TrimPrefix(cmd, "/")
+}
+
+// lookupCommand 查找命令，未找到时返回 nil
+func (b *Bot) lookupCommand(name string) *command {
+	if c, ok := b.commands[name]; ok {
+		return c
+	}
+	return b.commandsFold[strings.ToLower(name)]
+}
+
+// parseCommand 解析以命令开头的消息，返回命令名、@ 后的 bot 用户名和命令之后的文本
+func parseCommand(message *telegram.Message) (name string, mention string, rest string, ok bool) {
+	for _, entity := range message.Entities {
+		if entity.Type != telegram.MessageEntityAtBotCommand || entity.Offset != 0 {
+			continue
+		}
+
+		// 实体的偏移量和长度以 UTF-16 代码单元计算
+		text := utf16.Encode([]rune(message.Text))
+		if entity.Length <= 0 || int(entity.Length) > len(text) {
+			return "", "", "", false
+		}
+		cmd := string(utf16.Decode(text[:entity.Length]))
+		rest = strings.TrimSpace(string(utf16.Decode(text[entity.Length:])))
+
+		cmd = trimCommand(cmd)
+		if i := strings.IndexByte(cmd, '@'); i != -1 {
+			cmd, mention = cmd[:i], cmd[i+1:]
+		}
+		return cmd, mention, rest, true
+	}
+	return "", "", "", false
+}
+
+// SplitArgs 以 shell 风格分割参数：空白分隔，支持单引号、双引号以及反斜杠转义
+func SplitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, &ArgsError{Err: errors.New("unterminated quote")}
+	}
+	if escaped {
+		return nil, &ArgsError{Err: errors.New("trailing backslash")}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// ArgsError 命令参数错误，命令处理器返回该错误时会将错误信息回复给用户，而不是交给错误处理函数
+type ArgsError struct {
+	Arg string // 出错的参数名，与具体参数无关时为空
+	Err error  // 原始错误
+}
+
+// Error 实现 error 接口
+func (e *ArgsError) Error() string {
+	if e.Arg == "" {
+		return "invalid arguments: " + e.Err.Error()
+	}
+	return fmt.Sprintf("invalid argument %q: %s", e.Arg, e.Err)
+}
+
+// Unwrap 返回原始错误
+func (e *ArgsError) Unwrap() error {
+	return e.Err
+}
+
+// Command 获取匹配到的命令名（不含 / 和 @botusername），不是命令时为空
+func (c Context) Command() string {
+	return c.command
+}
+
+// ArgsText 获取命令之后的文本（未分割），不是命令时为空
+func (c Context) ArgsText() string {
+	return c.argsText
+}
+
+// Args 获取命令参数（shell 风格分割），不是命令时为 nil
+func (c Context) Args() []string {
+	return c.args
+}
+
+// ArgsValidator 实现该接口的参数结构体在绑定后会调用 Validate 进行校验
+type ArgsValidator interface {
+	Validate() error
+}
+
+// BindArgs 将命令参数按字段顺序绑定到结构体指针 ptr
+// 字段标签 `arg:"name,optional"`：name 为参数名（默认为小写字段名），optional 表示可以省略，"-" 表示忽略该字段。
+// 支持 string、bool、整数、浮点数，最后一个字段为 []string 时接收剩余的所有参数。
+// 参数缺失、过多或无法转换时返回 *ArgsError
+func (c Context) BindArgs(ptr interface{}) error {
+	if c.argsErr != nil {
+		return c.argsErr
+	}
+
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("BindArgs: ptr must be a pointer to struct")
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	args := c.args
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // 未导出
+			continue
+		}
+
+		name, optional := strings.ToLower(field.Name), false
+		if tag, ok := field.Tag.Lookup("arg"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "optional" {
+					optional = true
+				}
+			}
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String {
+			if len(args) == 0 && !optional {
+				return &ArgsError{Arg: name, Err: errors.New("missing")}
+			}
+			fv.Set(reflect.ValueOf(append([]string{}, args...)).Convert(fv.Type()))
+			args = nil
+			continue
+		}
+
+		if len(args) == 0 {
+			if optional {
+				continue
+			}
+			return &ArgsError{Arg: name, Err: errors.New("missing")}
+		}
+
+		if err := setArg(fv, args[0]); err != nil {
+			return &ArgsError{Arg: name, Err: err}
+		}
+		args = args[1:]
+	}
+
+	if len(args) != 0 {
+		return &ArgsError{Err: fmt.Errorf("too many arguments: %q", args)}
+	}
+
+	if validator, ok := ptr.(ArgsValidator); ok {
+		if err := validator.Validate(); err != nil {
+			var argsErr *ArgsError
+			if errors.As(err, &argsErr) {
+				return err
+			}
+			return &ArgsError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+// setArg 将参数转换为字段类型
+func setArg(fv reflect.Value, s string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		fv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("not a non-negative integer")
+		}
+		fv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return errors.New("not a number")
+		}
+		fv.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// replyArgsError 命令处理器返回 ArgsError 时回复用户，返回是否已处理
+func (b *Bot) replyArgsError(ctx *Context, c *command, err error) bool {
+	var argsErr *ArgsError
+	if !errors.As(err, &argsErr) {
+		return false
+	}
+
+	text := argsErr.Error()
+	if c != nil && c.optional.Usage != "" {
+		text += "\n" + c.optional.Usage
+	}
+	if _, err := ctx.SendMessage(ctx.GetChatID(), text, &telegram.SendMessageOptional{ReplyToMessageID: ctx.Message.MessageID}); err != nil {
+		b.handleUpdateError(ctx.Update, fmt.Errorf("reply arguments error: %w", err))
+	}
+	return true
+}
+
+// CommandSync 命令列表同步方式
+type CommandSync int
+
+const (
+	// CommandSyncAtAuto 至少有一个命令设置了说明时，运行时将差异同步到 telegram（默认）
+	CommandSyncAtAuto CommandSync = iota
+	// CommandSyncAtOff 不同步
+	CommandSyncAtOff
+	// CommandSyncAtDryRun 只将差异写入日志，不修改 telegram 的命令列表
+	CommandSyncAtDryRun
+)
+
+// CommandsDiff 已注册命令与 telegram 命令列表的差异
+type CommandsDiff struct {
+	LanguageCode string                // 语言代码，默认列表为空
+	Added        []telegram.BotCommand // 需要添加的命令
+	Removed      []telegram.BotCommand // 需要删除的命令
+	Changed      []telegram.BotCommand // 说明发生变化的命令（新说明）
+	Reordered    bool                  // 顺序发生变化
+
+	commands []telegram.BotCommand // 期望的命令列表
+}
+
+// Empty 是否没有差异
+func (d CommandsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Reordered
+}
+
+// String 实现 fmt.Stringer 接口
+func (d CommandsDiff) String() string {
+	language := d.LanguageCode
+	if language == "" {
+		language = "default"
+	}
+
+	var parts []string
+	for _, c := range d.Added {
+		parts = append(parts, "+/"+c.Command)
+	}
+	for _, c := range d.Removed {
+		parts = append(parts, "-/"+c.Command)
+	}
+	for _, c := range d.Changed {
+		parts = append(parts, "~/"+c.Command)
+	}
+	if d.Reordered {
+		parts = append(parts, "reordered")
+	}
+	return fmt.Sprintf("commands (%s): %s", language, strings.Join(parts, " "))
+}
+
+// BotCommands 根据已注册的命令生成指定语言的 telegram 命令列表（不含隐藏或没有说明的命令）
+// 命令名按注册时的名称发布；设置了 IgnoreCase 的命令以小写发布。
+// 命令名含大写字母且未设置 IgnoreCase 时不会发布：telegram 只接受小写的命令名，而小写的 /name 又无法匹配该命令
+func (b *Bot) BotCommands(languageCode string) []telegram.BotCommand {
+	var result []telegram.BotCommand
+	for _, c := range b.commandList {
+		if c.optional.Hidden {
+			continue
+		}
+
+		name := c.name
+		if c.optional.IgnoreCase {
+			name = strings.ToLower(name)
+		} else if name != strings.ToLower(name) {
+			continue
+		}
+
+		description := c.optional.Descriptions[languageCode]
+		if description == "" {
+			description = c.optional.Description
+		}
+		if description == "" {
+			continue
+		}
+		result = append(result, telegram.BotCommand{Command: name, Description: description})
+	}
+	return result
+}
+
+// commandLanguages 已注册命令使用的语言，默认语言（空字符串）在最前
+func (b *Bot) commandLanguages() []string {
+	set := map[string]struct{}{}
+	for _, c := range b.commandList {
+		if c.optional.Hidden {
+			continue
+		}
+		for languageCode := range c.optional.Descriptions {
+			set[languageCode] = struct{}{}
+		}
+	}
+
+	languages := make([]string, 0, len(set))
+	for languageCode := range set {
+		if languageCode != "" {
+			languages = append(languages, languageCode)
+		}
+	}
+	sort.Strings(languages)
+	return append([]string{""}, languages...)
+}
+
+// removedCommandLanguages 上次同步过、但已没有命令使用的语言
+func (b *Bot) removedCommandLanguages(languages []string) []string {
+	used := map[string]struct{}{}
+	for _, languageCode := range languages {
+		used[languageCode] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var removed []string
+	for languageCode := range b.syncedLanguages {
+		if _, ok := used[languageCode]; !ok {
+			removed = append(removed, languageCode)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// DiffCommands 比较已注册的命令与 telegram 的命令列表，每种语言返回一项（包括没有差异的）
+// 上次同步过（或通过 BotOptional.CommandLanguages 指定）但已没有命令使用的语言，期望的命令列表为空
+func (b *Bot) DiffCommands() ([]CommandsDiff, error) {
+	return b.diffCommands(b.API)
+}
+
+func (b *Bot) diffCommands(api *telegram.API) ([]CommandsDiff, error) {
+	languages := b.commandLanguages()
+	removed := b.removedCommandLanguages(languages)
+
+	var result []CommandsDiff
+	for i, languageCode := range append(languages, removed...) {
+		remote, err := api.GetMyCommandsWithOptional(&telegram.MyCommandsOptional{LanguageCode: languageCode})
+		if err != nil {
+			return nil, err
+		}
+		var local []telegram.BotCommand
+		if i < len(languages) { // 已移除的语言期望为空列表
+			local = b.BotCommands(languageCode)
+		}
+		result = append(result, diffBotCommands(languageCode, remote, local))
+	}
+	return result, nil
+}
+
+// diffBotCommands 比较两个命令列表
+func diffBotCommands(languageCode string, remote []telegram.BotCommand, local []telegram.BotCommand) CommandsDiff {
+	d := CommandsDiff{LanguageCode: languageCode, commands: local}
+
+	remoteDescriptions := map[string]string{}
+	for _, c := range remote {
+		remoteDescriptions[c.Command] = c.Description
+	}
+	localNames := map[string]struct{}{}
+	var common []string
+	for _, c := range local {
+		localNames[c.Command] = struct{}{}
+		description, ok := remoteDescriptions[c.Command]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, c)
+		case description != c.Description:
+			d.Changed = append(d.Changed, c)
+		}
+		if ok {
+			common = append(common, c.Command)
+		}
+	}
+
+	var remoteCommon []string
+	for _, c := range remote {
+		if _, ok := localNames[c.Command]; ok {
+			remoteCommon = append(remoteCommon, c.Command)
+		} else {
+			d.Removed = append(d.Removed, c)
+		}
+	}
+	d.Reordered = !reflect.DeepEqual(common, remoteCommon)
+
+	return d
+}
+
+// syncCommands 将已注册的命令同步到 telegram
+func (b *Bot) syncCommands(ctx context.Context) error {
+	if b.commandSync == CommandSyncAtOff {
+		return nil
+	}
+
+	described := false
+	for _, c := range b.commandList {
+		if c.optional.Description != "" || len(c.optional.Descriptions) != 0 {
+			described = true
+			break
+		}
+	}
+	if !described { // 没有任何命令设置说明，避免清空手动维护的命令列表
+		return nil
+	}
+
+	api := b.API.WithContext(ctx)
+	diffs, err := b.diffCommands(api)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range diffs {
+		if d.Empty() {
+			continue
+		}
+
+		if b.commandSync == CommandSyncAtDryRun {
+			b.logger.Println("dry run:", d)
+			continue
+		}
+
+		optional := &telegram.MyCommandsOptional{LanguageCode: d.LanguageCode}
+		if len(d.commands) == 0 {
+			_, err = api.DeleteMyCommands(optional)
+		} else {
+			_, err = api.SetMyCommandsWithOptional(d.commands, optional)
+		}
+		if err != nil {
+			return err
+		}
+		b.logger.Println("synced", d)
+	}
+
+	if b.commandSync != CommandSyncAtDryRun {
+		synced := map[string]struct{}{}
+		for _, d := range diffs {
+			if d.LanguageCode != "" && len(d.commands) != 0 {
+				synced[d.LanguageCode] = struct{}{}
+			}
+		}
+		b.mu.Lock()
+		b.syncedLanguages = synced
+		b.mu.Unlock()
+	}
+
+	return nil
+}
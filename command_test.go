@@ -0,0 +1,224 @@
+package tgbot
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{``, nil},
+		{`a b  c`, []string{"a", "b", "c"}},
+		{`"hello world" 'it''s' x\ y`, []string{"hello world", "its", "x y"}},
+		{`"" a`, []string{"", "a"}},
+		{`'a\b' "a\"b"`, []string{`a\b`, `a"b`}},
+	}
+	for _, c := range cases {
+		got, err := SplitArgs(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("SplitArgs(%q) = %q, 预期 %q", c.in, got, c.want)
+		}
+	}
+
+	if _, err := SplitArgs(`"open`); err == nil {
+		t.Fatal("未闭合的引号应返回错误")
+	}
+}
+
+type transferArgs struct {
+	To     string
+	Amount int
+	Note   []string `arg:"note,optional"`
+}
+
+func TestContext_BindArgs(t *testing.T) {
+	var args transferArgs
+	if err := (Context{args: []string{"bob", "10", "for", "lunch"}}).BindArgs(&args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, transferArgs{To: "bob", Amount: 10, Note: []string{"for", "lunch"}}) {
+		t.Fatalf("绑定结果错误: %+v", args)
+	}
+
+	for _, in := range [][]string{{"bob"}, {"bob", "ten"}} {
+		err := (Context{args: in}).BindArgs(&transferArgs{})
+		if _, ok := err.(*ArgsError); !ok {
+			t.Fatalf("%q 应返回 ArgsError，实际 %v", in, err)
+		}
+	}
+}
+
+func TestBot_CommandRouter(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: "group"}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, `/start@test_bot "a b" c`),
+		textUpdate(2, chat, nil, `/start@other_bot x`),
+		textUpdate(3, chat, nil, `/BEGIN y`),
+		textUpdate(4, chat, nil, `/pay bob ten`),
+	)
+	f.results["sendMessage"] = `{"message_id":99}`
+
+	b := f.newBot(nil)
+	got := make(chan string, 8)
+	b.AddCommand("/start", func(c *Context) error {
+		got <- c.Command() + ":" + strings.Join(c.Args(), "|")
+		return nil
+	}, &CommandOptional{Aliases: []string{"begin"}, IgnoreCase: true})
+	b.AddCommand("pay", func(c *Context) error {
+		var args transferArgs
+		if err := c.BindArgs(&args); err != nil {
+			return err
+		}
+		got <- "pay"
+		return nil
+	}, &CommandOptional{Usage: "/pay <to> <amount>"})
+	runBot(t, b)
+
+	want := []string{"start:a b|c", "BEGIN:y"}
+	if result := collect(t, got, 2); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Calls("sendMessage")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("参数错误未回复给用户")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if body := f.Calls("sendMessage")[0].Body; !strings.Contains(body, "amount") || !strings.Contains(body, "/pay \\u003cto\\u003e") {
+		t.Fatalf("参数错误回复内容错误: %s", body)
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("不应处理: %s", s)
+	default:
+	}
+}
+
+func TestBot_CommandReplace(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, `/begin x`),
+		textUpdate(2, chat, nil, `/START y`),
+		textUpdate(3, chat, nil, `/start a b`),
+	)
+
+	b := f.newBot(nil)
+	got := make(chan string, 8)
+	b.AddCommand("start", func(c *Context) error {
+		got <- "old:" + c.Command()
+		return nil
+	}, &CommandOptional{Aliases: []string{"begin"}, IgnoreCase: true})
+	b.AddCommand("start", func(c *Context) error {
+		got <- "new:" + c.Message.Text + ":" + c.ArgsText() + ":" + c.Update.Message.Text
+		return nil
+	}, nil)
+	b.SetDefaultCommandProcessor(func(c *Context) error {
+		got <- "default:" + c.Command() + ":" + c.Message.Text
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"default:begin:/begin x", "default:START:/START y", "new:a b:a b:/start a b"}
+	if result := collect(t, got, 3); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+}
+
+func TestBot_SyncCommands(t *testing.T) {
+	for _, mode := range []CommandSync{CommandSyncAtAuto, CommandSyncAtDryRun} {
+		f := newFakeTelegram(t)
+		f.results["getMyCommands"] = `[{"command":"start","description":"old"},{"command":"stale","description":"stale"}]`
+
+		b := f.newBot(&BotOptional{CommandSync: mode})
+		b.AddCommand("/start", func(c *Context) error { return nil }, &CommandOptional{Description: "start", Descriptions: map[string]string{"zh": "开始"}})
+		b.AddCommand("/help", func(c *Context) error { return nil }, &CommandOptional{Description: "help"})
+		b.AddCommand("/debug", func(c *Context) error { return nil }, &CommandOptional{Description: "debug", Hidden: true})
+
+		diffs, err := b.DiffCommands()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 2 || diffs[0].LanguageCode != "" || diffs[1].LanguageCode != "zh" {
+			t.Fatalf("语言错误: %v", diffs)
+		}
+		if d := diffs[0]; len(d.Added) != 1 || d.Added[0].Command != "help" || len(d.Removed) != 1 || d.Removed[0].Command != "stale" || len(d.Changed) != 1 {
+			t.Fatalf("差异错误: %v", d)
+		}
+
+		runBot(t, b)
+		waitRunning(t, b)
+
+		calls := f.Calls("setMyCommands")
+		if mode == CommandSyncAtDryRun {
+			if len(calls) != 0 {
+				t.Fatalf("dry run 不应修改命令列表: %v", calls)
+			}
+			continue
+		}
+		if len(calls) != 2 {
+			t.Fatalf("应同步 2 种语言，实际 %d 次", len(calls))
+		}
+		if body := calls[0].Body; !strings.Contains(body, `{"command":"start","description":"start"},{"command":"help","description":"help"}`) || strings.Contains(body, "debug") {
+			t.Fatalf("同步内容错误: %s", body)
+		}
+		if body := calls[1].Body; !strings.Contains(body, `"language_code":"zh"`) || !strings.Contains(body, "开始") {
+			t.Fatalf("同步内容错误: %s", body)
+		}
+	}
+}
+
+func TestBot_BotCommandsName(t *testing.T) {
+	b := New(1, "token", nil)
+	b.AddCommand("/start", func(c *Context) error { return nil }, &CommandOptional{Description: "start"})
+	b.AddCommand("/Help", func(c *Context) error { return nil }, &CommandOptional{Description: "help", IgnoreCase: true})
+	b.AddCommand("/Debug", func(c *Context) error { return nil }, &CommandOptional{Description: "debug"})
+
+	want := []telegram.BotCommand{{Command: "start", Description: "start"}, {Command: "help", Description: "help"}}
+	if got := b.BotCommands(""); !reflect.DeepEqual(got, want) {
+		t.Fatalf("命令列表 %v 与预期 %v 不一致", got, want)
+	}
+}
+
+func TestBot_SyncCommandsRemovedLanguage(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.results["getMyCommands"] = `[{"command":"start","description":"old"}]`
+	b := f.newBot(&BotOptional{CommandLanguages: []string{"fr"}})
+	b.AddCommand("/start", func(c *Context) error { return nil }, &CommandOptional{Description: "start", Descriptions: map[string]string{"zh": "开始"}})
+
+	deleted := func() []string {
+		var result []string
+		for _, call := range f.Calls("deleteMyCommands") {
+			result = append(result, call.Body)
+		}
+		return result
+	}
+
+	if err := b.syncCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := deleted(); len(calls) != 1 || !strings.Contains(calls[0], `"language_code":"fr"`) {
+		t.Fatalf("应删除 fr 的命令列表: %v", calls)
+	}
+
+	// zh 不再使用后删除上次同步的 zh 命令列表
+	b.AddCommand("/start", func(c *Context) error { return nil }, &CommandOptional{Description: "start"})
+	if err := b.syncCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := deleted(); len(calls) != 2 || !strings.Contains(calls[1], `"language_code":"zh"`) {
+		t.Fatalf("应删除 zh 的命令列表: %v", calls)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 
 	"github.com/elissa2333/tgbot/telegram"
 	"github.com/elissa2333/tgbot/utils"
@@ -41,25 +42,102 @@ const (
 	ContextTypeAtVenue = "venue"
 	// ContextTypeAtLocation 共享位置
 	ContextTypeAtLocation = "location"
+
+	// ContextTypeAtNewChatMembers 服务消息：新成员加入
+	ContextTypeAtNewChatMembers = "new_chat_members"
+	// ContextTypeAtLeftChatMember 服务消息：成员离开
+	ContextTypeAtLeftChatMember = "left_chat_member"
+	// ContextTypeAtNewChatTitle 服务消息：会话标题已修改
+	ContextTypeAtNewChatTitle = "new_chat_title"
+	// ContextTypeAtNewChatPhoto 服务消息：会话照片已修改
+	ContextTypeAtNewChatPhoto = "new_chat_photo"
+	// ContextTypeAtDeleteChatPhoto 服务消息：会话照片已删除
+	ContextTypeAtDeleteChatPhoto = "delete_chat_photo"
+	// ContextTypeAtGroupChatCreated 服务消息：群组已创建
+	ContextTypeAtGroupChatCreated = "group_chat_created"
+	// ContextTypeAtPinnedMessage 服务消息：消息已置顶
+	ContextTypeAtPinnedMessage = "pinned_message"
+	// ContextTypeAtMigrateToChat 服务消息：群组已升级为超级群组（在原群组中）
+	ContextTypeAtMigrateToChat = "migrate_to_chat_id"
+	// ContextTypeAtMigrateFromChat 服务消息：超级群组由群组升级而来（在新的超级群组中）
+	ContextTypeAtMigrateFromChat = "migrate_from_chat_id"
 )
 
 // Context 上下文
 type Context struct {
 	*telegram.API                   // 所有 api 方法
 	MessageType   string            // 消息类型
-	Message       *telegram.Message // 接收到的消息
+	Message       *telegram.Message // 接收到的消息（包括已编辑的消息和频道帖子，其他类型的更新为 nil）
+	Update        *telegram.Update  // 接收到的更新
+
+	reply *webhookReply // webhook 内联响应，长轮询或未启用时为 nil
+
+	command  string   // 匹配到的命令
+	argsText string   // 命令之后的文本
+	args     []string // 命令参数
+	argsErr  error    // 分割命令参数时的错误
+
+	bot          *Bot                 // 所属的 bot
+	conversation *conversationRuntime // 会话状态，首次访问时加载
+	session      *sessionRuntime      // 会话数据，首次访问时加载
+
+	pattern *regexp.Regexp // 通过 HandleText 或 AddCallbackPattern 匹配时的正则
+	matches []string       // 匹配时的子匹配
+
+	callbackData     string // 通过 AddCallback 匹配时前缀之后的回调数据
+	callbackAnswered bool   // 是否已应答回调查询
+
+	lastReply *telegram.Message // 最后一次通过 Send、Reply 等方法发送的消息
+
+	migrationKnown bool // 收到 migrate_to_chat_id 服务消息前已得知该群组升级（OnChatMigrated 已调用）
+}
+
+// GetChat 获取更新所属的会话，无法确定时返回 nil
+func (c Context) GetChat() *telegram.Chat {
+	if c.Message != nil {
+		return c.Message.Chat
+	}
+	if c.Update != nil && c.Update.CallbackQuery != nil && c.Update.CallbackQuery.Message != nil {
+		return c.Update.CallbackQuery.Message.Chat
+	}
+	return nil
 }
 
 // GetChatID 获取会话 ID
 func (c Context) GetChatID() string {
-	if c.Message != nil {
-		if c.Message.Chat != nil {
-			return utils.ToString(c.Message.Chat.ID)
-		}
+	if chat := c.GetChat(); chat != nil {
+		return utils.ToString(chat.ID)
 	}
 	return ""
 }
 
+// GetFrom 获取触发更新的用户，无法确定时（如频道帖子）返回 nil
+func (c Context) GetFrom() *telegram.User {
+	if c.Message != nil {
+		return c.Message.From
+	}
+	if c.Update == nil {
+		return nil
+	}
+
+	u := c.Update
+	switch {
+	case u.InlineQuery != nil:
+		return u.InlineQuery.From
+	case u.ChosenInlineResult != nil:
+		return u.ChosenInlineResult.From
+	case u.CallbackQuery != nil:
+		return u.CallbackQuery.From
+	case u.ShippingQuery != nil:
+		return u.ShippingQuery.From
+	case u.PreCheckoutQuery != nil:
+		return u.PreCheckoutQuery.From
+	case u.PollAnswer != nil:
+		return u.PollAnswer.User
+	}
+	return nil
+}
+
 // GetDownloadURL 获取文件下载地址下载地址
 func (c Context) GetDownloadURL(filePath string) string {
 	return fmt.Sprintf("https://api.telegram.org/file/bot%d:%s/%s", c.ID, c.Token, filePath)
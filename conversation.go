@@ -0,0 +1,390 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elissa2333/tgbot/utils"
+)
+
+// ConversationState 会话状态
+type ConversationState struct {
+	Conversation string            `json:"conversation"` // 会话名
+	State        string            `json:"state"`        // 当前状态
+	Data         map[string]string `json:"data"`         // 会话中收集的数据
+	UpdatedAt    time.Time         `json:"updated_at"`   // 最后一次更新的时间
+}
+
+// StateStorage 会话状态存储，键由会话 ID 与用户 ID 组成
+type StateStorage interface {
+	GetState(key string) (*ConversationState, error) // 不存在时返回 nil, nil
+	SetState(key string, state *ConversationState) error
+	DeleteState(key string) error
+}
+
+// MemoryStateStorage 内存会话状态存储（重启后丢失）
+type MemoryStateStorage struct {
+	mu     sync.Mutex
+	states map[string]ConversationState
+}
+
+// NewMemoryStateStorage 新建内存会话状态存储
+func NewMemoryStateStorage() *MemoryStateStorage {
+	return &MemoryStateStorage{states: map[string]ConversationState{}}
+}
+
+// GetState 实现 StateStorage 接口
+func (s *MemoryStateStorage) GetState(key string) (*ConversationState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return nil, nil
+	}
+	state.Data = copyStateData(state.Data)
+	return &state, nil
+}
+
+// SetState 实现 StateStorage 接口
+func (s *MemoryStateStorage) SetState(key string, state *ConversationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := *state
+	v.Data = copyStateData(state.Data)
+	s.states[key] = v
+	return nil
+}
+
+// DeleteState 实现 StateStorage 接口
+func (s *MemoryStateStorage) DeleteState(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
+
+// copyStateData 复制会话数据，避免存储与处理器共享同一个 map
+func copyStateData(data map[string]string) map[string]string {
+	result := make(map[string]string, len(data))
+	for k, v := range data {
+		result[k] = v
+	}
+	return result
+}
+
+// ErrNoConversation 当前更新不在会话中
+var ErrNoConversation = errors.New("tgbot: not in a conversation")
+
+// ConversationOptional 会话可选参数
+type ConversationOptional struct {
+	ExitCommands []string             // 退出命令，默认为 /cancel
+	Timeout      time.Duration        // 超过该时间没有新的更新时会话结束，为0时不超时
+	OnTimeout    MessageProcessorFunc // 会话超时后收到下一条更新时调用，之后该更新按没有会话的情况继续处理
+	OnExit       MessageProcessorFunc // 通过退出命令结束会话时调用
+	Fallback     MessageProcessorFunc // 当前状态没有处理器时调用，未设置时更新按没有会话的情况处理
+}
+
+// Conversation 会话（多步骤交互），由进入命令开始，处理器通过 Context.SetState 切换状态，
+// 通过 Context.EndConversation 或退出命令结束。同一会话中同一用户同时只能处于一个会话
+type Conversation struct {
+	name     string
+	entries  map[string]MessageProcessorFunc // 进入命令（小写，不含 /）
+	states   map[string]MessageProcessorFunc // 状态处理器
+	optional ConversationOptional
+}
+
+// AddConversation 添加会话
+func (b *Bot) AddConversation(name string, optional *ConversationOptional) *Conversation {
+	cv := &Conversation{
+		name:    name,
+		entries: map[string]MessageProcessorFunc{},
+		states:  map[string]MessageProcessorFunc{},
+	}
+	if optional != nil {
+		cv.optional = *optional
+	}
+	if len(cv.optional.ExitCommands) == 0 {
+		cv.optional.ExitCommands = []string{"/cancel"}
+	}
+
+	if b.conversations == nil {
+		b.conversations = map[string]*Conversation{}
+	}
+	if old, ok := b.conversations[name]; ok { // 重复添加时替换，保持原有顺序
+		for i := range b.conversationList {
+			if b.conversationList[i] == old {
+				b.conversationList[i] = cv
+			}
+		}
+	} else {
+		b.conversationList = append(b.conversationList, cv)
+	}
+	b.conversations[name] = cv
+	return cv
+}
+
+// Entry 添加进入命令，处理器中调用 Context.SetState 后会话才会开始
+// 多个会话使用同一进入命令时，先添加的会话优先
+func (cv *Conversation) Entry(cmd string, fn MessageProcessorFunc) *Conversation {
+	cv.entries[strings.ToLower(trimCommand(cmd))] = fn
+	return cv
+}
+
+// State 添加状态处理器
+func (cv *Conversation) State(state string, fn MessageProcessorFunc) *Conversation {
+	cv.states[state] = fn
+	return cv
+}
+
+// isExit 判断命令是否为退出命令
+func (cv *Conversation) isExit(name string) bool {
+	for _, cmd := range cv.optional.ExitCommands {
+		if strings.EqualFold(trimCommand(cmd), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStateStorage 设置会话状态存储，默认为内存存储
+func (b *Bot) SetStateStorage(storage StateStorage) {
+	b.stateStorage = storage
+}
+
+// conversationRuntime 处理单个更新时的会话状态
+type conversationRuntime struct {
+	key      string
+	state    *ConversationState // 当前会话，nil 表示不在会话中
+	entering string             // 正在执行进入命令的会话名
+	loaded   bool               // 是否已从存储加载
+	dirty    bool
+	unlock   func()
+}
+
+// conversationKey 获取会话状态的键（会话 ID:用户 ID），无法确定时返回空字符串
+func conversationKey(c *Context) string {
+	chat, user := c.GetChat(), c.GetFrom()
+	if chat == nil || user == nil {
+		return ""
+	}
+	return utils.ToString(chat.ID) + ":" + utils.ToString(user.ID)
+}
+
+// conversationLockKey 会话状态在 sessionLocks 中的键，与会话数据的键区分
+func conversationLockKey(key string) string {
+	return "conversation:" + key
+}
+
+// lockConversation 锁定会话状态的键，处理结束后由 saveConversation 或 releaseConversation 释放
+// 同一键的会话状态在处理期间加锁（串行键不按会话与用户区分时，并发的更新也不会覆盖彼此的状态）
+func (b *Bot) lockConversation(c *Context) (*conversationRuntime, error) {
+	if c.conversation != nil {
+		return c.conversation, nil
+	}
+
+	key := conversationKey(c)
+	if key == "" {
+		return nil, ErrNoConversation
+	}
+
+	c.conversation = &conversationRuntime{key: key, unlock: b.sessionLocks.lock(conversationLockKey(key))}
+	return c.conversation, nil
+}
+
+// loadConversation 加锁并加载会话状态
+func (b *Bot) loadConversation(c *Context) (*conversationRuntime, error) {
+	r, err := b.lockConversation(c)
+	if err != nil || r.loaded {
+		return r, err
+	}
+
+	state, err := b.stateStorage.GetState(r.key)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil && state.Data == nil {
+		state.Data = map[string]string{}
+	}
+	r.state, r.loaded = state, true
+	return r, nil
+}
+
+// saveConversation 保存处理器对会话状态的修改并释放锁
+func (b *Bot) saveConversation(c *Context) error {
+	r := c.conversation
+	if r == nil {
+		return nil
+	}
+	defer r.unlock()
+	c.conversation = nil
+
+	if !r.dirty {
+		return nil
+	}
+	r.dirty = false
+
+	if r.state == nil {
+		return b.stateStorage.DeleteState(r.key)
+	}
+	r.state.UpdatedAt = time.Now()
+	return b.stateStorage.SetState(r.key, r.state)
+}
+
+// releaseConversation 释放会话状态的锁，不保存修改
+func (b *Bot) releaseConversation(c *Context) {
+	r := c.conversation
+	if r == nil {
+		return
+	}
+	c.conversation = nil
+	r.unlock()
+}
+
+// handleConversation 将更新交给会话处理，返回更新是否已被会话处理
+func (b *Bot) handleConversation(c *Context) bool {
+	if len(b.conversations) == 0 || conversationKey(c) == "" {
+		return false
+	}
+
+	r, err := b.loadConversation(c)
+	if err != nil {
+		b.handleUpdateError(c.Update, fmt.Errorf("load conversation: %w", err))
+		return false
+	}
+
+	var name string
+	if c.Message != nil && c.Update.Message != nil {
+		if cmd, mention, _, ok := parseCommand(c.Message); ok && (mention == "" || b.username == "" || strings.EqualFold(mention, b.username)) {
+			name = strings.ToLower(cmd)
+		}
+	}
+
+	if r.state != nil {
+		cv := b.conversations[r.state.Conversation]
+		if cv == nil { // 会话已不存在
+			r.state, r.dirty = nil, true
+		} else if cv.optional.Timeout > 0 && time.Since(r.state.UpdatedAt) > cv.optional.Timeout {
+			r.state, r.dirty = nil, true
+			b.runConversationHandler(c, cv.optional.OnTimeout, "timeout")
+		} else if name != "" && cv.isExit(name) {
+			r.state, r.dirty = nil, true
+			b.runConversationHandler(c, cv.optional.OnExit, "exit")
+			return true
+		} else {
+			fn := cv.states[r.state.State]
+			if fn == nil {
+				fn = cv.optional.Fallback
+			}
+			if fn != nil {
+				b.runConversationHandler(c, fn, r.state.State)
+				return true
+			}
+		}
+	}
+
+	if name != "" {
+		for _, cv := range b.conversationList {
+			if fn, ok := cv.entries[name]; ok {
+				r.entering = cv.name
+				b.runConversationHandler(c, fn, "entry")
+				r.entering = ""
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// runConversationHandler 执行会话处理器
+func (b *Bot) runConversationHandler(c *Context, fn MessageProcessorFunc, label string) {
+	if fn == nil {
+		return
+	}
+
+	if err := b.invoke(c, fn); err != nil && !errors.Is(err, ErrContinue) {
+		b.handleUpdateError(c.Update, fmt.Errorf("conversation %s: %w", label, err))
+	}
+}
+
+// State 获取当前会话的状态，不在会话中时为空
+func (c *Context) State() string {
+	r, err := c.bot.loadConversation(c)
+	if err != nil || r.state == nil {
+		return ""
+	}
+	return r.state.State
+}
+
+// ConversationName 获取当前会话名，不在会话中时为空
+func (c *Context) ConversationName() string {
+	r, err := c.bot.loadConversation(c)
+	if err != nil || r.state == nil {
+		return ""
+	}
+	return r.state.Conversation
+}
+
+// SetState 切换当前会话的状态，在进入命令的处理器中调用时开始会话
+// 不在会话中时返回 ErrNoConversation，修改在处理器结束后保存
+func (c *Context) SetState(state string) error {
+	r, err := c.bot.loadConversation(c)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case r.entering != "":
+		r.state = &ConversationState{Conversation: r.entering, State: state, Data: map[string]string{}}
+	case r.state != nil:
+		r.state.State = state
+	default:
+		return ErrNoConversation
+	}
+	r.dirty = true
+	return nil
+}
+
+// StartConversation 开始指定的会话（可以在任意处理器中调用），已在其他会话中时会被替换
+func (c *Context) StartConversation(name string, state string) error {
+	if _, ok := c.bot.conversations[name]; !ok {
+		return fmt.Errorf("tgbot: conversation %q not found", name)
+	}
+
+	r, err := c.bot.loadConversation(c)
+	if err != nil {
+		return err
+	}
+	r.state = &ConversationState{Conversation: name, State: state, Data: map[string]string{}}
+	r.dirty = true
+	return nil
+}
+
+// StateData 获取当前会话的数据，可以直接修改，处理器结束后保存。不在会话中时返回 nil
+func (c *Context) StateData() map[string]string {
+	r, err := c.bot.loadConversation(c)
+	if err != nil || r.state == nil {
+		return nil
+	}
+	r.dirty = true
+	return r.state.Data
+}
+
+// EndConversation 结束当前会话
+func (c *Context) EndConversation() error {
+	r, err := c.bot.loadConversation(c)
+	if err != nil {
+		return err
+	}
+	if r.state == nil {
+		return ErrNoConversation
+	}
+	r.state, r.dirty = nil, true
+	return nil
+}
@@ -0,0 +1,131 @@
+package tgbot
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_Conversation(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	user := &telegram.User{ID: 200}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, user, "hi"),
+		textUpdate(2, chat, user, "/order"),
+		textUpdate(3, chat, user, "apple"),
+		textUpdate(4, chat, user, "home"),
+		textUpdate(5, chat, user, "/order"),
+		textUpdate(6, chat, user, "/cancel"),
+		textUpdate(7, chat, user, "bye"),
+	)
+	b := f.newBot(nil)
+	got := make(chan string, 16)
+	b.AddConversation("order", nil).
+		Entry("/order", func(c *Context) error {
+			got <- "entry"
+			return c.SetState("product")
+		}).
+		State("product", func(c *Context) error {
+			c.StateData()["product"] = c.Message.Text
+			got <- "product:" + c.Message.Text
+			return c.SetState("address")
+		}).
+		State("address", func(c *Context) error {
+			got <- "done:" + c.StateData()["product"] + "@" + c.Message.Text
+			return c.EndConversation()
+		})
+	b.SetMessageProcessor(func(c *Context) error {
+		got <- "default:" + c.Message.Text + ":" + c.State()
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"default:hi:", "entry", "product:apple", "done:apple@home", "entry", "default:bye:"}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+
+	state, err := b.stateStorage.GetState("100:200")
+	if err != nil || state != nil {
+		t.Fatalf("退出后会话状态应被删除: %v %v", state, err)
+	}
+}
+
+func TestBot_ConversationEntryOrder(t *testing.T) {
+	var updates []telegram.Update
+	for i := int64(1); i <= 8; i++ {
+		updates = append(updates, telegram.Update{UpdateID: i, Message: &telegram.Message{
+			MessageID: i, From: &telegram.User{ID: 200}, Chat: &telegram.Chat{ID: 100, Type: "private"}, Text: "/start",
+			Entities: []telegram.MessageEntity{{Type: telegram.MessageEntityAtBotCommand, Length: 6}},
+		}})
+	}
+	f := newFakeTelegram(t, updates...)
+	b := f.newBot(nil)
+	got := make(chan string, 16)
+	for _, name := range []string{"first", "second", "third", "first"} { // 重复添加时保持原有顺序
+		name := name
+		b.AddConversation(name, nil).Entry("/start", func(c *Context) error {
+			got <- name
+			return nil
+		})
+	}
+	runBot(t, b)
+
+	for _, name := range collect(t, got, len(updates)) {
+		if name != "first" {
+			t.Fatalf("进入命令交给了 %s，应交给先添加的会话", name)
+		}
+	}
+}
+
+func TestBot_ConversationConcurrent(t *testing.T) {
+	const n = 20
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	user := &telegram.User{ID: 200}
+	var updates []telegram.Update
+	for i := int64(1); i <= n; i++ {
+		updates = append(updates, textUpdate(i, chat, user, "+1"))
+	}
+
+	f := newFakeTelegram(t, updates...)
+	// 串行键不按会话与用户区分，同一会话的更新会被并发处理
+	b := f.newBot(&BotOptional{Workers: 4, KeyFunc: func(update *telegram.Update) string {
+		return strconv.FormatInt(update.UpdateID, 10)
+	}})
+	if err := b.stateStorage.SetState("100:200", &ConversationState{Conversation: "count", State: "counting", Data: map[string]string{"n": "0"}, UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, n)
+	b.AddConversation("count", nil).
+		Entry("/count", func(c *Context) error { return c.SetState("counting") }).
+		State("counting", func(c *Context) error {
+			data := c.StateData()
+			count, _ := strconv.Atoi(data["n"])
+			time.Sleep(time.Millisecond)
+			data["n"] = strconv.Itoa(count + 1)
+			got <- "ok"
+			return nil
+		})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+	collect(t, got, n)
+	if err := b.Shutdown(context.Background()); err != nil { // 等待处理结束（会话状态已保存）
+		t.Fatal(err)
+	}
+	if err := <-runErr; err != ErrBotClosed {
+		t.Fatal(err)
+	}
+
+	state, err := b.stateStorage.GetState("100:200")
+	if err != nil || state == nil || state.Data["n"] != strconv.Itoa(n) {
+		t.Fatalf("并发更新的会话状态被覆盖: %+v %v", state, err)
+	}
+}
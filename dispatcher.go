@@ -0,0 +1,138 @@
+package tgbot
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elissa2333/tgbot/telegram"
+	"github.com/elissa2333/tgbot/utils"
+)
+
+// KeyFunc 计算更新的串行键，键相同的更新按接收顺序依次处理，返回空字符串则不限制顺序
+// KeyFunc 在接收更新的协程中调用，发生 panic 时错误交给错误处理函数，该更新视为返回空字符串
+type KeyFunc func(update *telegram.Update) string
+
+// DefaultKeyFunc 默认串行键：优先使用会话 ID，没有会话时使用用户 ID
+func DefaultKeyFunc(update *telegram.Update) string {
+	c := Context{Update: update, Message: getUpdateMessage(update)}
+	if chat := c.GetChat(); chat != nil {
+		return "chat:" + utils.ToString(chat.ID)
+	}
+	if user := c.GetFrom(); user != nil {
+		return "user:" + utils.ToString(user.ID)
+	}
+	return ""
+}
+
+// ChatKeyFunc 按会话 ID 串行
+func ChatKeyFunc(update *telegram.Update) string {
+	c := Context{Update: update, Message: getUpdateMessage(update)}
+	if chat := c.GetChat(); chat != nil {
+		return utils.ToString(chat.ID)
+	}
+	return ""
+}
+
+// UserKeyFunc 按用户 ID 串行
+func UserKeyFunc(update *telegram.Update) string {
+	c := Context{Update: update, Message: getUpdateMessage(update)}
+	if user := c.GetFrom(); user != nil {
+		return utils.ToString(user.ID)
+	}
+	return ""
+}
+
+// job 待处理的更新
+type job struct {
+	update *telegram.Update
+	reply  *webhookReply      // webhook 内联响应，长轮询或未启用时为 nil
+	album  []*telegram.Update // 合并处理的相册，update 为其中第一条
+
+	migration bool // 通过失败的请求得知的群组升级，update 为生成的服务消息，只交给 OnChatMigrated 设置的处理器
+}
+
+// dispatcher 更新调度器
+// 每个工作协程拥有一个有界队列，串行键相同的更新总是进入同一个队列，从而保证顺序；
+// 不同键的更新分散到不同的工作协程并行处理。队列已满时 submit 阻塞，形成背压
+type dispatcher struct {
+	queues  []chan *job
+	keyFunc KeyFunc
+	handle  func(j *job)
+	next    uint32 // 没有串行键时轮流选择队列
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newDispatcher 新建调度器并启动工作协程
+func newDispatcher(workers int, queueSize int, keyFunc KeyFunc, handle func(j *job)) *dispatcher {
+	d := &dispatcher{
+		queues:  make([]chan *job, workers),
+		keyFunc: keyFunc,
+		handle:  handle,
+	}
+
+	d.wg.Add(workers)
+	for i := range d.queues {
+		queue := make(chan *job, queueSize)
+		d.queues[i] = queue
+		go func() {
+			defer d.wg.Done()
+			for j := range queue {
+				d.handle(j)
+			}
+		}()
+	}
+
+	return d
+}
+
+// submit 提交更新，队列已满时阻塞直到有空位或 ctx 结束
+func (d *dispatcher) submit(ctx context.Context, j *job) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrBotClosed
+	}
+
+	select {
+	case d.queues[d.shard(j.update)] <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shard 选择更新所属的队列
+func (d *dispatcher) shard(update *telegram.Update) int {
+	key := d.keyFunc(update)
+	if key == "" {
+		return int(atomic.AddUint32(&d.next, 1) % uint32(len(d.queues)))
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// close 停止接收新的更新，已在队列中的更新仍会被处理
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	d.closed = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+}
+
+// wait 等待所有已提交的更新处理完毕
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,118 @@
+package tgbot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// chatUpdate 生成指定会话的更新
+func chatUpdate(id int64, chatID int64) *telegram.Update {
+	return &telegram.Update{UpdateID: id, Message: &telegram.Message{MessageID: id, Chat: &telegram.Chat{ID: chatID}}}
+}
+
+func TestDispatcher_Order(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[int64][]int64{}
+	d := newDispatcher(4, 8, ChatKeyFunc, func(j *job) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := j.update.Message.Chat.ID
+		handled[chatID] = append(handled[chatID], j.update.UpdateID)
+	})
+
+	for i := int64(0); i < 100; i++ {
+		if err := d.submit(context.Background(), &job{update: chatUpdate(i, i%5)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.close()
+	d.wait()
+
+	for chatID, ids := range handled {
+		if len(ids) != 20 {
+			t.Fatalf("会话 %d 处理了 %d 个更新", chatID, len(ids))
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("会话 %d 的更新顺序 %v 与接收顺序不一致", chatID, ids)
+			}
+		}
+	}
+	if err := d.submit(context.Background(), &job{update: chatUpdate(100, 0)}); err != ErrBotClosed {
+		t.Fatalf("关闭后提交返回 %v", err)
+	}
+}
+
+func TestDispatcher_Parallel(t *testing.T) {
+	started := make(chan int64, 2)
+	release := make(chan struct{})
+	d := newDispatcher(4, 8, ChatKeyFunc, func(j *job) {
+		started <- j.update.Message.Chat.ID
+		<-release
+	})
+	defer d.wait()
+	defer d.close()
+	defer close(release)
+
+	// 找到与会话 1 不在同一队列的会话
+	other := int64(2)
+	for d.shard(chatUpdate(0, other)) == d.shard(chatUpdate(0, 1)) {
+		other++
+	}
+
+	for _, chatID := range []int64{1, other} {
+		if err := d.submit(context.Background(), &job{update: chatUpdate(chatID, chatID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("不同串行键的更新没有并行处理")
+		}
+	}
+}
+
+func TestDispatcher_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	d := newDispatcher(1, 1, ChatKeyFunc, func(j *job) {
+		<-release
+	})
+	defer d.wait()
+	defer d.close()
+	defer close(release)
+
+	// 第一个更新由工作协程处理，第二个更新占满队列
+	for i := int64(1); i <= 2; i++ {
+		if err := d.submit(context.Background(), &job{update: chatUpdate(i, 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	submitErr := make(chan error, 1)
+	go func() {
+		submitErr <- d.submit(ctx, &job{update: chatUpdate(3, 1)})
+	}()
+	select {
+	case err := <-submitErr:
+		t.Fatalf("队列已满时 submit 不应返回: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-submitErr:
+		if err != context.Canceled {
+			t.Fatalf("取消后 submit 返回 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后 submit 没有返回")
+	}
+}
@@ -0,0 +1,129 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// ErrorHandlerFunc 错误处理函数
+// u 为引发错误的更新，与更新无关的错误（如主动处理器返回的错误）为 nil
+type ErrorHandlerFunc func(err error, u *telegram.Update)
+
+// ErrorPolicy 处理器错误的处理策略
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyAtContinue 交给错误处理函数后继续运行（默认）
+	ErrorPolicyAtContinue ErrorPolicy = iota
+	// ErrorPolicyAtStopOnFatal 交给错误处理函数后，如果是致命错误（见 Fatal）则停止运行，Run 返回该错误
+	ErrorPolicyAtStopOnFatal
+)
+
+// UpdateError 处理更新时产生的错误
+type UpdateError struct {
+	Update *telegram.Update // 引发错误的更新
+	Err    error            // 原始错误
+}
+
+// Error 实现 error 接口
+func (e *UpdateError) Error() string {
+	if e.Update == nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("update %d: %s", e.Update.UpdateID, e.Err)
+}
+
+// Unwrap 返回原始错误
+func (e *UpdateError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError 处理器发生 panic 时产生的错误
+type PanicError struct {
+	Value interface{} // panic 的值
+	Stack []byte      // 发生 panic 时的堆栈
+}
+
+// Error 实现 error 接口
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// fatalError 致命错误
+type fatalError struct {
+	err error
+}
+
+// Error 实现 error 接口
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap 返回原始错误
+func (e *fatalError) Unwrap() error {
+	return e.err
+}
+
+// Fatal 将处理器返回的错误标记为致命错误，在 ErrorPolicyAtStopOnFatal 策略下会使 bot 停止运行
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err: err}
+}
+
+// IsFatal 判断错误是否被标记为致命错误
+func IsFatal(err error) bool {
+	var e *fatalError
+	return errors.As(err, &e)
+}
+
+// SetErrorHandler 设置处理器错误处理函数（默认写入日志）
+func (b *Bot) SetErrorHandler(fn ErrorHandlerFunc) {
+	b.errorHandlerFunc = fn
+}
+
+// SetErrorPolicy 设置处理器错误处理策略
+func (b *Bot) SetErrorPolicy(policy ErrorPolicy) {
+	b.errorPolicy = policy
+}
+
+// handleUpdateError 处理处理器返回的错误，错误会携带引发它的更新
+func (b *Bot) handleUpdateError(update *telegram.Update, err error) {
+	if err == nil {
+		return
+	}
+
+	err = &UpdateError{Update: update, Err: err}
+	if b.errorHandlerFunc != nil {
+		b.errorHandlerFunc(err, update)
+	} else {
+		b.logger.Println(err)
+	}
+
+	if b.errorPolicy == ErrorPolicyAtStopOnFatal && IsFatal(err) {
+		b.handleError(err)
+	}
+}
+
+// safeKeyFunc 调用 KeyFunc 计算串行键，KeyFunc 发生 panic 时交给错误处理函数并不限制该更新的顺序
+func (b *Bot) safeKeyFunc(update *telegram.Update) (key string) {
+	defer func() {
+		if v := recover(); v != nil {
+			key = ""
+			b.handleUpdateError(update, fmt.Errorf("KeyFunc: %w", &PanicError{Value: v, Stack: debug.Stack()}))
+		}
+	}()
+	return b.keyFunc(update)
+}
+
+// recoverPanic 捕获处理器中的 panic，转换为 PanicError 后交给错误处理函数
+// 必须直接通过 defer 调用
+func (b *Bot) recoverPanic(update *telegram.Update) {
+	if v := recover(); v != nil {
+		b.handleUpdateError(update, &PanicError{Value: v, Stack: debug.Stack()})
+	}
+}
@@ -0,0 +1,145 @@
+package tgbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_RecoverPanic(t *testing.T) {
+	chat := &telegram.Chat{ID: 100}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, "panic"),
+		textUpdate(2, chat, nil, "key"),
+		textUpdate(3, chat, nil, "ok"),
+	)
+	b := f.newBot(&BotOptional{Workers: 1, KeyFunc: func(update *telegram.Update) string {
+		if update.UpdateID == 2 {
+			panic("bad key")
+		}
+		return DefaultKeyFunc(update)
+	}})
+
+	errs := make(chan string, 8)
+	b.SetErrorHandler(func(err error, u *telegram.Update) {
+		var pe *PanicError
+		var ue *UpdateError
+		if !errors.As(err, &pe) || !errors.As(err, &ue) || ue.Update != u {
+			errs <- "unexpected:" + err.Error()
+			return
+		}
+		errs <- fmt.Sprint("panic:", u.UpdateID, ":", pe.Value)
+	})
+	got := make(chan string, 8)
+	b.SetMessageProcessor(func(c *Context) error {
+		if c.Message.Text == "panic" {
+			panic("boom")
+		}
+		got <- c.Message.Text
+		return nil
+	})
+	runBot(t, b)
+
+	// KeyFunc 的 panic 在接收协程中发生，与处理器的 panic 顺序不确定
+	result := collect(t, errs, 2)
+	if result[0] > result[1] {
+		result[0], result[1] = result[1], result[0]
+	}
+	if want := []string{"panic:1:boom", "panic:2:bad key"}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("错误 %v 与预期 %v 不一致", result, want)
+	}
+	if result := collect(t, got, 2); !reflect.DeepEqual(result, []string{"key", "ok"}) {
+		t.Fatalf("panic 后处理结果 %v 与预期不一致", result)
+	}
+}
+
+// syncBuffer 可以并发读写的缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestBot_ErrorPolicyContinue(t *testing.T) {
+	chat := &telegram.Chat{ID: 100}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, "fail"),
+		textUpdate(2, chat, nil, "ok"),
+	)
+	var logs syncBuffer
+	b := f.newBot(&BotOptional{Workers: 1, Logger: log.New(&logs, "", 0)})
+
+	got := make(chan string, 4)
+	b.SetMessageProcessor(func(c *Context) error {
+		if c.Message.Text == "fail" {
+			return Fatal(errors.New("boom")) // 默认策略下致命错误同样只记录
+		}
+		got <- c.Message.Text
+		return nil
+	})
+	runBot(t, b)
+
+	if result := collect(t, got, 1); result[0] != "ok" {
+		t.Fatalf("错误后处理结果 %v 与预期不一致", result)
+	}
+	if s := logs.String(); !strings.Contains(s, "update 1: ") || !strings.Contains(s, "boom") {
+		t.Fatalf("错误未写入日志: %q", s)
+	}
+}
+
+func TestBot_ErrorPolicyStopOnFatal(t *testing.T) {
+	chat := &telegram.Chat{ID: 100}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, "fail"),
+		textUpdate(2, chat, nil, "fatal"),
+	)
+	b := f.newBot(&BotOptional{Workers: 1, Logger: log.New(ioutil.Discard, "", 0)})
+	b.SetErrorPolicy(ErrorPolicyAtStopOnFatal)
+
+	errBoom := errors.New("boom")
+	b.SetMessageProcessor(func(c *Context) error {
+		if c.Message.Text == "fatal" {
+			return Fatal(errBoom)
+		}
+		return errors.New("not fatal")
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+	var err error
+	select {
+	case err = <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("致命错误后 Run 没有返回")
+	}
+
+	var ue *UpdateError
+	if !errors.As(err, &ue) || ue.Update == nil || ue.Update.UpdateID != 2 {
+		t.Fatalf("Run 返回 %v，应为更新 2 的 UpdateError", err)
+	}
+	if !errors.Is(err, errBoom) || !IsFatal(err) {
+		t.Fatalf("Run 返回的错误 %v 应包含原始错误", err)
+	}
+}
@@ -2,6 +2,7 @@ package tgbot
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/elissa2333/tgbot/telegram"
@@ -37,3 +38,23 @@ func ExampleBot_SetInlineQueryProcessor() {
 		panic(err)
 	}
 }
+
+func ExampleBot_WebhookHandler() {
+	tg := New(utils.ToInt(os.Getenv("id")), os.Getenv("token"), nil)
+	tg.SetMessageProcessor(func(c *Context) error {
+		_, err := c.SendMessage(c.GetChatID(), c.Message.Text, nil)
+		return err
+	})
+
+	if err := tg.SetWebhook("https://example.com/bot", "", nil); err != nil { // 不启动内置服务
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/bot", tg.WebhookHandler())
+	go http.ListenAndServe(":8080", mux)
+
+	if err := tg.Run(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,130 @@
+package tgbot
+
+import (
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// Filter 过滤器，判断更新是否应交给处理器，可以通过 And、Or、Not 组合
+type Filter func(c *Context) bool
+
+// And 所有过滤器都满足时满足
+func And(filters ...Filter) Filter {
+	return func(c *Context) bool {
+		for _, f := range filters {
+			if !f(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or 任一过滤器满足时满足
+func Or(filters ...Filter) Filter {
+	return func(c *Context) bool {
+		for _, f := range filters {
+			if f(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not 过滤器不满足时满足
+func Not(filter Filter) Filter {
+	return func(c *Context) bool {
+		return !filter(c)
+	}
+}
+
+// Where 将过滤器转换为中间件，不满足时跳过该处理器并返回 ErrContinue，更新继续交给后续匹配的处理器。
+// 配合 Group 可以为任意处理器添加过滤条件
+//
+//	admin := b.Group(Where(FromUser(adminID), Private()))
+func Where(filters ...Filter) Middleware {
+	filter := And(filters...)
+	return func(next MessageProcessorFunc) MessageProcessorFunc {
+		return func(c *Context) error {
+			if !filter(c) {
+				return ErrContinue
+			}
+			return next(c)
+		}
+	}
+}
+
+// ChatType 会话类型为 types 之一（telegram.ChatTypeAt*）
+func ChatType(types ...string) Filter {
+	return func(c *Context) bool {
+		chat := c.GetChat()
+		if chat == nil {
+			return false
+		}
+		for _, t := range types {
+			if chat.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Private 私聊
+func Private() Filter {
+	return ChatType(telegram.ChatTypeAtPrivate)
+}
+
+// GroupChat 群组或超级群组
+func GroupChat() Filter {
+	return ChatType(telegram.ChatTypeAtGroup, telegram.ChatTypeAtSuperGroup)
+}
+
+// FromUser 由指定用户之一触发
+func FromUser(ids ...int64) Filter {
+	return func(c *Context) bool {
+		user := c.GetFrom()
+		if user == nil {
+			return false
+		}
+		for _, id := range ids {
+			if user.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HasReply 消息是对另一条消息的回复
+func HasReply() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.ReplyToMessage != nil
+	}
+}
+
+// HasEntity 消息文本或说明中包含指定类型的实体（telegram.MessageEntityAt*）
+func HasEntity(types ...string) Filter {
+	return func(c *Context) bool {
+		if c.Message == nil {
+			return false
+		}
+		for _, entities := range [][]telegram.MessageEntity{c.Message.Entities, c.Message.CaptionEntities} {
+			for _, e := range entities {
+				for _, t := range types {
+					if e.Type == t {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+}
+
+// Forwarded 消息是转发的消息
+func Forwarded() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.ForwardDate != 0
+	}
+}
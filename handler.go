@@ -0,0 +1,195 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrContinue 处理器返回该错误时，更新继续交给下一个匹配的处理器，否则处理器执行后停止传递
+var ErrContinue = errors.New("tgbot: continue to next handler")
+
+// 处理器阶段，同一优先级中按阶段顺序匹配
+const (
+	handlerStageAtCommand        = iota // 命令处理器
+	handlerStageAtDefaultCommand        // 默认命令处理器（消息以命令开头但命令未注册）
+	handlerStageAtText                  // 文本处理器（HandleText）
+	handlerStageAtTyped                 // 指定类型消息处理器
+	handlerStageAtDefault               // 默认处理器
+)
+
+// handler 已注册的处理器
+type handler struct {
+	key      string // 通过 Set* 注册的处理器的键，重复设置时替换，为空时不替换
+	priority int
+	stage    int
+	seq      int                    // 注册顺序
+	match    func(c *Context) bool  // 为 nil 时总是匹配
+	run      func(c *Context) error // 执行处理器（包括中间件）
+	label    string                 // 错误信息前缀
+}
+
+// handlerList 按优先级（从高到低）、阶段、注册顺序排列的处理器
+type handlerList struct {
+	handlers []*handler
+	seq      int
+}
+
+// add 添加处理器，key 不为空且已存在时替换原有的处理器
+func (l *handlerList) add(h *handler) {
+	l.seq++
+	h.seq = l.seq
+
+	if h.key != "" {
+		for i, old := range l.handlers {
+			if old.key == h.key {
+				l.handlers = append(l.handlers[:i], l.handlers[i+1:]...)
+				break
+			}
+		}
+	}
+
+	l.handlers = append(l.handlers, h)
+	sort.Slice(l.handlers, func(i, j int) bool {
+		a, b := l.handlers[i], l.handlers[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.stage != b.stage {
+			return a.stage < b.stage
+		}
+		return a.seq < b.seq
+	})
+}
+
+// empty 是否没有处理器
+func (l *handlerList) empty() bool {
+	return len(l.handlers) == 0
+}
+
+// handle 依次执行匹配的处理器，直到某个处理器没有返回 ErrContinue，返回是否有处理器执行
+func (b *Bot) handle(l *handlerList, c *Context) bool {
+	handled := false
+	for _, h := range l.handlers {
+		if h.match != nil && !h.match(c) {
+			continue
+		}
+
+		handled = true
+		err := h.run(c)
+		if errors.Is(err, ErrContinue) {
+			continue
+		}
+		if err != nil {
+			if h.label != "" {
+				err = fmt.Errorf("%s: %w", h.label, err)
+			}
+			b.handleUpdateError(c.Update, err)
+		}
+		break
+	}
+	return handled
+}
+
+// invoker 生成经过全局中间件执行 fn 的处理器
+func (b *Bot) invoker(fn MessageProcessorFunc) func(c *Context) error {
+	return func(c *Context) error {
+		return b.invoke(c, fn)
+	}
+}
+
+// HandleMessage 添加消息处理器，可以添加多个。contextType 为消息类型（ContextTypeAt*），为空时处理所有类型的消息
+// 处理顺序见 HandleText，同一阶段中按添加顺序执行，处理器返回 ErrContinue 时继续交给下一个匹配的处理器
+func (b *Bot) HandleMessage(contextType string, fn MessageProcessorFunc, filters ...Filter) {
+	b.handleMessage(0, contextType, fn, filters)
+}
+
+// handleMessage 添加指定优先级的消息处理器
+func (b *Bot) handleMessage(priority int, contextType string, fn MessageProcessorFunc, filters []Filter) {
+	filter := And(filters...)
+	h := &handler{priority: priority, stage: handlerStageAtDefault, run: b.invoker(fn), label: "HandleMessage"}
+	if contextType == "" {
+		h.match = filter
+	} else {
+		h.stage = handlerStageAtTyped
+		h.match = func(c *Context) bool {
+			return c.MessageType == contextType && filter(c)
+		}
+	}
+	b.messageHandlers.add(h)
+}
+
+// HandleInlineQuery 添加内联查询处理器，可以添加多个，处理器返回 ErrContinue 时继续交给下一个匹配的处理器
+func (b *Bot) HandleInlineQuery(fn InlineQueryProcessorFunc, filters ...Filter) {
+	b.handleInlineQuery(0, "", inlineQueryProcessor(fn), filters)
+}
+
+// handleInlineQuery 添加指定优先级的内联查询处理器
+func (b *Bot) handleInlineQuery(priority int, key string, fn MessageProcessorFunc, filters []Filter) {
+	b.inlineHandlers.add(&handler{
+		key:      key,
+		priority: priority,
+		stage:    handlerStageAtDefault,
+		match:    And(filters...),
+		run:      b.invoker(fn),
+	})
+}
+
+// inlineQueryProcessor 将内联查询处理器转换为通用处理器
+func inlineQueryProcessor(fn InlineQueryProcessorFunc) MessageProcessorFunc {
+	return func(c *Context) error {
+		return fn(&InlineQueryContext{API: c.API, InlineQuery: c.Update.InlineQuery, ctx: c})
+	}
+}
+
+// handleReceivedMessages 处理接收的消息
+// 以命令开头且 @ 了其他 bot 的消息会被忽略，其他消息依次交给匹配的处理器（见 HandleText）
+func (b *Bot) handleReceivedMessages(c *Context) {
+	if name, mention, rest, ok := parseCommand(c.Message); ok {
+		/*命令格式
+		/foo
+		/foo bar "baz qux"
+		/foo@botusername bar
+		*/
+		if mention != "" && b.username != "" && !strings.EqualFold(mention, b.username) { // 发给其他 bot 的命令
+			return
+		}
+
+		c.command, c.argsText = name, rest
+		c.args, c.argsErr = SplitArgs(rest)
+	}
+
+	b.handle(&b.messageHandlers, c)
+}
+
+// Priority 设置分组的优先级，通过分组注册的消息与内联查询处理器按优先级从高到低匹配，默认为0（子分组继承父分组）
+func (g *Group) Priority(priority int) *Group {
+	g.priority, g.hasPriority = priority, true
+	return g
+}
+
+// getPriority 获取分组的优先级
+func (g *Group) getPriority() int {
+	for p := g; p != nil; p = p.parent {
+		if p.hasPriority {
+			return p.priority
+		}
+	}
+	return 0
+}
+
+// HandleMessage 在分组中添加消息处理器
+func (g *Group) HandleMessage(contextType string, fn MessageProcessorFunc, filters ...Filter) {
+	g.bot.handleMessage(g.getPriority(), contextType, g.wrap(fn), filters)
+}
+
+// HandleInlineQuery 在分组中添加内联查询处理器
+func (g *Group) HandleInlineQuery(fn InlineQueryProcessorFunc, filters ...Filter) {
+	g.bot.handleInlineQuery(g.getPriority(), "", g.wrap(inlineQueryProcessor(fn)), filters)
+}
+
+// SetInlineQueryProcessor 在分组中设置内联查询处理器，与 Bot.SetInlineQueryProcessor 互相替换
+func (g *Group) SetInlineQueryProcessor(fn InlineQueryProcessorFunc) {
+	g.bot.handleInlineQuery(g.getPriority(), "inline", g.wrap(inlineQueryProcessor(fn)), nil)
+}
@@ -0,0 +1,76 @@
+package tgbot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_HandlerPrecedence(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtPrivate}
+	user := &telegram.User{ID: 200}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, user, "/start"),
+		textUpdate(2, chat, user, "/help me"),
+		textUpdate(3, chat, user, "/unknown"),
+		textUpdate(4, chat, user, "hi"),
+		messageUpdate(5, chat, user, telegram.Message{Photo: []telegram.PhotoSize{{FileID: "p"}}}),
+		telegram.Update{UpdateID: 6, InlineQuery: &telegram.InlineQuery{ID: "q", From: user, Query: "find"}},
+	)
+	b := f.newBot(&BotOptional{Workers: 1})
+	got := make(chan string, 32)
+	record := func(s string, err error) MessageProcessorFunc {
+		return func(c *Context) error {
+			got <- s
+			return err
+		}
+	}
+
+	b.AddCommand("/start", record("command:start", nil), nil)
+	b.AddCommand("/help", func(c *Context) error {
+		got <- "command:help:" + c.Message.Text
+		return ErrContinue
+	}, nil)
+	b.HandleMessage(ContextTypeAtText, func(c *Context) error {
+		got <- "typed:all:" + c.Message.Text
+		return ErrContinue
+	})
+	b.SetMessageProcessorAtText(func(c *TextMessageContext) error {
+		got <- "typed:text:" + c.Text
+		return nil
+	})
+	b.SetMessageProcessor(record("default", nil))
+	b.Group().Priority(10).HandleMessage("", record("audit", ErrContinue))
+
+	b.HandleInlineQuery(func(c *InlineQueryContext) error {
+		got <- "inline:first:" + c.Query
+		return ErrContinue
+	})
+	b.SetInlineQueryProcessor(func(c *InlineQueryContext) error {
+		got <- "inline:set"
+		return nil
+	})
+	b.HandleInlineQuery(func(c *InlineQueryContext) error {
+		got <- "inline:unreachable"
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{
+		"audit", "command:start", // 命令处理器执行后停止传递
+		"audit", "command:help:me", "typed:all:/help me", "typed:text:/help me", // ErrContinue 后恢复原文本继续传递
+		"audit", "typed:all:/unknown", "typed:text:/unknown", // 没有默认命令处理器时交给消息处理器
+		"audit", "typed:all:hi", "typed:text:hi",
+		"audit", "default", // 没有指定类型处理器时交给默认处理器
+		"inline:first:find", "inline:set",
+	}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果\n%v\n与预期\n%v\n不一致", result, want)
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("不应处理: %s", s)
+	default:
+	}
+}
@@ -1,16 +1,16 @@
 package tgbot
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"log"
 	"net/http"
-	stdURL "net/url"
-	"strings"
-
-	"github.com/elissa2333/httpc"
+	"os"
+	"runtime"
+	"sync"
+	"time"
 
 	"github.com/elissa2333/tgbot/telegram"
 	"github.com/elissa2333/tgbot/utils"
@@ -26,104 +26,164 @@ type ActiveProcessorFunc func(api *telegram.API) error
 type Bot struct {
 	API *telegram.API // telegram api
 
-	timeout uint // 长时间轮询的超时时间（以秒为单位）为0即通常的短轮询。应该为正，短轮询应仅用于测试目的
+	timeout        uint     // 长时间轮询的超时时间（以秒为单位）为0即通常的短轮询。应该为正，短轮询应仅用于测试目的
+	limit          uint     // 长轮询每次获取的更新数量
+	allowedUpdates []string // 长轮询订阅的更新类型，为空时根据已注册的处理器自动生成
+
+	webHookEngine      func(ctx context.Context) error
+	webhookSecretToken string           // 设置 webhook 时指定的密钥
+	webhookSecurity    *WebhookSecurity // webhook 请求校验
+	webhookMetrics     *WebhookMetrics  // webhook 请求统计
 
-	webHookEngine func() error
+	webhookReplyTimeout time.Duration // webhook 内联响应的等待时间，为0时不启用
 
 	MsgOffset int64 // 最后一条消息
 
+	offsets          *offsetTracker  // 偏移量确认，未设置偏移量存储时为 nil
+	idempotencyCheck IdempotencyFunc // 幂等检查
+
 	activeProcessorFunc []ActiveProcessorFunc
 
-	commands       map[string]MessageProcessorFunc // 指定命令的执行方法
-	defaultCommand MessageProcessorFunc            // 默认命令未指定命令时使用
+	commands     map[string]*command // 指定命令的执行方法（包括别名）
+	commandsFold map[string]*command // 忽略大小写的命令（键为小写）
+	commandList  []*command          // 按注册顺序排列的命令
+	commandSync  CommandSync         // 命令列表同步方式
+	username     string              // bot 用户名，运行时通过 GetMe 获取
+
+	syncedLanguages map[string]struct{} // 上次同步到 telegram 的语言（不含默认语言），没有命令再使用时删除其命令列表
+
+	conversations    map[string]*Conversation // 会话
+	conversationList []*Conversation          // 按添加顺序排列的会话
+	stateStorage     StateStorage             // 会话状态存储
+
+	sessionStore    SessionStore     // 会话数据存储，未设置时不可用
+	sessionOptional SessionOptional  // 会话数据可选参数
+	sessionLocks    keyedMutex       // 会话数据按键加锁
+	sessionSaved    sessionSaveTimes // 会话数据最后一次保存的时间
+
+	albums albumCollector // 相册收集器
+
+	migrationMu    sync.Mutex
+	migratedChats  map[int64]int64           // 已知的群组升级，键为原群组 ID
+	chatMigratedFn ChatMigratedProcessorFunc // OnChatMigrated 设置的处理器
+
+	callbacks        []*callbackRoute // 回调路由
+	callbackStore    SessionStore     // 回调数据的服务端存储
+	callbackStoreTTL time.Duration    // 回调数据的有效期
+
+	messageHandlers handlerList // 消息处理器（命令、文本、指定类型与默认处理器）
+	inlineHandlers  handlerList // 内联查询处理器
 
-	specifiedTypeMessageProcessorFunc map[string]interface{} // 指定类型消息处理器
-	defaultMessageProcessorFunc       MessageProcessorFunc   // 默认消息处理器
+	updateProcessorFunc map[string]interface{} // 其他类型更新处理器
+	done                chan struct{}          // 退出程序
+	err                 chan error
 
-	inlineQueryProcessorFunc InlineQueryProcessorFunc // 内联处理函数
-	done                     chan struct{}            // 退出程序
-	err                      chan error
+	middleware []Middleware // 全局中间件
+
+	errorHandlerFunc ErrorHandlerFunc // 处理器错误处理函数
+	errorPolicy      ErrorPolicy      // 处理器错误处理策略
+	logger           *log.Logger      // 日志
+
+	mu         sync.Mutex         // 保护运行状态
+	stop       context.CancelFunc // 停止获取更新
+	engineDone chan struct{}      // 更新获取引擎（长轮询或 webhook 服务）已退出
+	closed     chan struct{}      // 已调用 Shutdown
+	closeOnce  sync.Once
+	dispatcher *dispatcher // 更新调度器，运行时创建
+
+	workers   int     // 处理更新的工作协程数量
+	queueSize int     // 每个工作协程的队列长度
+	keyFunc   KeyFunc // 串行键
 }
 
+// ErrBotClosed 调用 Shutdown 后 Run 返回的错误
+var ErrBotClosed = errors.New("tgbot: Bot closed")
+
 // BotOptional bot 配置可选参数
 type BotOptional struct {
 	HTTPClient *http.Client
-	Timeout    uint // // 长时间轮询的超时时间（以秒为单位）为0即通常的短轮询。应该为正，短轮询应仅用于测试目的
-}
+	Timeout    uint        // // 长时间轮询的超时时间（以秒为单位）为0即通常的短轮询。应该为正，短轮询应仅用于测试目的
+	Logger     *log.Logger // 日志，默认输出到标准错误
 
-// New 新建 bot
-func New(id int, token string, optional *BotOptional) *Bot {
-	b := &Bot{
-		API:      telegram.New(nil, id, token),
-		timeout:  15,
-		commands: map[string]MessageProcessorFunc{},
-		done:     make(chan struct{}),
-		err:      make(chan error),
-	}
+	Limit          uint     // 长轮询每次获取的更新数量，1-100，默认（为0时）为100，超过100时按100处理
+	AllowedUpdates []string // 长轮询订阅的更新类型（telegram.UpdateTypeAt*），为空时根据已注册的处理器自动生成
 
-	if optional != nil {
-		b.timeout = optional.Timeout
+	Workers   int     // 处理更新的工作协程数量，默认（为0时）为 CPU 核心数
+	QueueSize int     // 每个工作协程的队列长度，队列已满时暂停接收更新，默认（为0时）为64
+	KeyFunc   KeyFunc // 串行键，键相同的更新按顺序处理，默认为 DefaultKeyFunc（同一会话或用户）
 
-		if optional.HTTPClient != nil {
-			b.API = telegram.New(optional.HTTPClient, id, token)
-		}
-	}
+	CommandSync      CommandSync // 运行时如何将已注册命令的说明同步到 telegram 的命令列表
+	CommandLanguages []string    // 以前同步过的语言代码，没有命令再使用这些语言时删除其命令列表（进程重启后 bot 无法得知上次同步过哪些语言）
 
-	return b
+	RetryMigratedChat bool // 请求因群组升级为超级群组而失败时，是否将 chat_id 替换为新的超级群组 ID 后重试一次（升级事件见 OnChatMigrated）
 }
 
-// SetWebhook 设置 webhook
-func (b *Bot) SetWebhook(url string /*API 访问地址*/, address string /*本地监听地址*/, optional *telegram.WebhookOptional) error {
-	parseURL, err := stdURL.Parse(url)
-	if err != nil {
-		return err
-	}
+const (
+	maxUpdatesLimit  = 100 // 每次获取更新数量的上限
+	defaultQueueSize = 64  // 默认队列长度
+)
 
-	if err := b.API.SetWebhook(url, optional); err != nil {
-		return err
+// New 新建 bot
+func New(id int, token string, optional *BotOptional) *Bot {
+	b := &Bot{
+		API:            telegram.New(nil, id, token),
+		timeout:        15,
+		limit:          maxUpdatesLimit,
+		commands:       map[string]*command{},
+		done:           make(chan struct{}, 1),
+		err:            make(chan error, 1),
+		closed:         make(chan struct{}),
+		webhookMetrics: &WebhookMetrics{},
+		logger:         log.New(os.Stderr, "tgbot: ", log.LstdFlags),
+		workers:        runtime.NumCPU(),
+		queueSize:      defaultQueueSize,
+		keyFunc:        DefaultKeyFunc,
+		stateStorage:   NewMemoryStateStorage(),
 	}
 
-	b.webHookEngine = func() error {
-		http.HandleFunc(parseURL.Path, func(writer http.ResponseWriter, request *http.Request) {
-			defer request.Body.Close()
-			if request.Method != http.MethodPost {
-				writer.WriteHeader(http.StatusMethodNotAllowed)
-				return
-			}
-
-			if request.Header.Get(httpc.ContentType) != httpc.MIMEJson {
-				writer.WriteHeader(http.StatusBadRequest)
-				return
+	if optional != nil {
+		b.timeout = optional.Timeout
+		b.allowedUpdates = optional.AllowedUpdates
+		b.commandSync = optional.CommandSync
+		for _, languageCode := range optional.CommandLanguages {
+			if languageCode == "" {
+				continue
 			}
-
-			bodyB, err := ioutil.ReadAll(request.Body)
-			if err != nil {
-				b.handleError(err)
-				return
+			if b.syncedLanguages == nil {
+				b.syncedLanguages = map[string]struct{}{}
 			}
+			b.syncedLanguages[languageCode] = struct{}{}
+		}
 
-			m := telegram.Update{}
-			if err := json.Unmarshal(bodyB, &m); err != nil {
-				b.handleError(err)
-				return
-			}
+		if optional.Limit != 0 && optional.Limit < maxUpdatesLimit {
+			b.limit = optional.Limit
+		}
 
-			if m.UpdateID == 0 { // 坏请求
-				writer.WriteHeader(http.StatusBadRequest)
-				return
-			}
+		if optional.Logger != nil {
+			b.logger = optional.Logger
+		}
 
-			if b.inlineQueryProcessorFunc != nil {
-				b.handleInlineQuery(m.InlineQuery)
-			} else {
-				b.handleReceivedMessages(m.Message)
-			}
-		})
+		if optional.Workers > 0 {
+			b.workers = optional.Workers
+		}
+		if optional.QueueSize > 0 {
+			b.queueSize = optional.QueueSize
+		}
+		if optional.KeyFunc != nil {
+			b.keyFunc = optional.KeyFunc
+		}
 
-		return http.ListenAndServe(address, nil)
+		if optional.HTTPClient != nil {
+			b.API = telegram.New(optional.HTTPClient, id, token)
+		}
 	}
 
-	return nil
+	b.API.HandleChatMigration(&telegram.MigrationOptional{
+		Retry:      optional != nil && optional.RetryMigratedChat,
+		OnMigrated: b.chatMigrated,
+	})
+
+	return b
 }
 
 // DeleteWebhook  删除 webhook
@@ -139,12 +199,31 @@ func (b *Bot) GetWebhookInfo() (*telegram.WebhookInfo, error) {
 
 // AddCommandProcessor 添加命令处理器（接收到命令后调用）
 func (b *Bot) AddCommandProcessor(cmd string, execFunc MessageProcessorFunc) {
-	b.commands[cmd] = execFunc
+	b.AddCommand(cmd, execFunc, nil)
 }
 
 // SetDefaultCommandProcessor 设置默认命令处理器（在未找到命令时调用）
 func (b *Bot) SetDefaultCommandProcessor(execFunc MessageProcessorFunc) {
-	b.defaultCommand = execFunc
+	b.setDefaultCommandProcessor(0, execFunc)
+}
+
+// setDefaultCommandProcessor 设置指定优先级的默认命令处理器
+func (b *Bot) setDefaultCommandProcessor(priority int, execFunc MessageProcessorFunc) {
+	b.messageHandlers.add(&handler{
+		key:      "default_command",
+		priority: priority,
+		stage:    handlerStageAtDefaultCommand,
+		match: func(c *Context) bool {
+			return c.command != "" && b.lookupCommand(c.command) == nil
+		},
+		run: func(c *Context) error {
+			err := b.invoke(c, execFunc)
+			if err != nil && !errors.Is(err, ErrContinue) && b.replyArgsError(c, nil, err) {
+				return nil
+			}
+			return err
+		},
+	})
 }
 
 // MessageContextBase 基础上下文信息
@@ -162,6 +241,8 @@ type MessageContextBase struct {
 	ForwardSenderName    string         // 可选的。从用户转发的邮件的发件人名称，这些用户不允许在转发的邮件中添加指向其帐户的链接
 	ForwardDate          int64          // 可选的。对于转发的消息，原始消息的发送日期为Unix时间
 	ViaBot               *telegram.User // 可选的。发送消息的机器人
+
+	ctx *Context // 所属的通用上下文
 }
 
 // GetChatID 获取聊天 ID
@@ -426,32 +507,192 @@ func (b *Bot) SetMessageProcessorAtLocation(fn ProcessorAtLocationFunc) {
 	b.setMessageProcessorAt(ContextTypeAtLocation, fn)
 }
 
-// setMessageProcessorAt 设置指定消息类型的处理器
+// setMessageProcessorAt 设置指定消息类型的处理器，重复设置时替换（添加多个见 HandleMessage）
 func (b *Bot) setMessageProcessorAt(typeS string, fn interface{}) {
-	if b.specifiedTypeMessageProcessorFunc == nil {
-		b.specifiedTypeMessageProcessorFunc = map[string]interface{}{}
-	}
-
-	b.specifiedTypeMessageProcessorFunc[typeS] = fn
+	b.messageHandlers.add(&handler{
+		key:   "type:" + typeS,
+		stage: handlerStageAtTyped,
+		match: func(c *Context) bool {
+			return c.MessageType == typeS
+		},
+		run: b.invoker(typedMessageProcessor(fn)),
+	})
 }
 
-// SetMessageProcessor 消息处理器（接收到消息后调用）
+// SetMessageProcessor 消息处理器（接收到消息后调用），重复设置时替换（添加多个见 HandleMessage）
 func (b *Bot) SetMessageProcessor(handleMessageFunc MessageProcessorFunc) {
-	b.defaultMessageProcessorFunc = handleMessageFunc
+	b.setMessageProcessor(0, handleMessageFunc)
+}
+
+// setMessageProcessor 设置指定优先级的默认消息处理器
+func (b *Bot) setMessageProcessor(priority int, handleMessageFunc MessageProcessorFunc) {
+	b.messageHandlers.add(&handler{
+		key:      "default",
+		priority: priority,
+		stage:    handlerStageAtDefault,
+		run:      b.invoker(handleMessageFunc),
+		label:    "SetMessageProcessor",
+	})
 }
 
 // InlineQueryContext 内联调用上下文
 type InlineQueryContext struct {
 	*telegram.API
 	*telegram.InlineQuery
+
+	ctx *Context // 所属的通用上下文
 }
 
 // InlineQueryProcessorFunc 内联处理函数
 type InlineQueryProcessorFunc func(c *InlineQueryContext) error
 
-// SetInlineQueryProcessor 设置内联处理器
+// SetInlineQueryProcessor 设置内联处理器，重复设置时替换（添加多个见 HandleInlineQuery）
 func (b *Bot) SetInlineQueryProcessor(fn InlineQueryProcessorFunc) {
-	b.inlineQueryProcessorFunc = fn
+	b.handleInlineQuery(0, "inline", inlineQueryProcessor(fn), nil)
+}
+
+// EditedMessageContext 已编辑消息上下文
+type EditedMessageContext struct {
+	*Context
+}
+
+// EditedMessageProcessorFunc 已编辑消息处理函数
+type EditedMessageProcessorFunc func(c *EditedMessageContext) error
+
+// SetEditedMessageProcessor 设置已编辑消息处理器
+func (b *Bot) SetEditedMessageProcessor(fn EditedMessageProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtEditedMessage, fn)
+}
+
+// ChannelPostContext 频道帖子上下文
+type ChannelPostContext struct {
+	*Context
+}
+
+// ChannelPostProcessorFunc 频道帖子处理函数
+type ChannelPostProcessorFunc func(c *ChannelPostContext) error
+
+// SetChannelPostProcessor 设置频道帖子处理器
+func (b *Bot) SetChannelPostProcessor(fn ChannelPostProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtChannelPost, fn)
+}
+
+// EditedChannelPostContext 已编辑频道帖子上下文
+type EditedChannelPostContext struct {
+	*Context
+}
+
+// EditedChannelPostProcessorFunc 已编辑频道帖子处理函数
+type EditedChannelPostProcessorFunc func(c *EditedChannelPostContext) error
+
+// SetEditedChannelPostProcessor 设置已编辑频道帖子处理器
+func (b *Bot) SetEditedChannelPostProcessor(fn EditedChannelPostProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtEditedChannelPost, fn)
+}
+
+// ChosenInlineResultContext 已选择内联结果上下文
+type ChosenInlineResultContext struct {
+	*telegram.API
+	*telegram.ChosenInlineResult
+
+	ctx *Context // 所属的通用上下文
+}
+
+// ChosenInlineResultProcessorFunc 已选择内联结果处理函数
+type ChosenInlineResultProcessorFunc func(c *ChosenInlineResultContext) error
+
+// SetChosenInlineResultProcessor 设置已选择内联结果处理器
+func (b *Bot) SetChosenInlineResultProcessor(fn ChosenInlineResultProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtChosenInlineResult, fn)
+}
+
+// CallbackQueryContext 回调查询上下文
+type CallbackQueryContext struct {
+	*telegram.API
+	*telegram.CallbackQuery
+
+	ctx *Context // 所属的通用上下文
+}
+
+// CallbackQueryProcessorFunc 回调查询处理函数
+type CallbackQueryProcessorFunc func(c *CallbackQueryContext) error
+
+// SetCallbackQueryProcessor 设置回调查询处理器（嵌入式键盘按钮被按下时调用）
+func (b *Bot) SetCallbackQueryProcessor(fn CallbackQueryProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtCallbackQuery, fn)
+}
+
+// ShippingQueryContext 收货查询上下文
+type ShippingQueryContext struct {
+	*telegram.API
+	*telegram.ShippingQuery
+
+	ctx *Context // 所属的通用上下文
+}
+
+// ShippingQueryProcessorFunc 收货查询处理函数
+type ShippingQueryProcessorFunc func(c *ShippingQueryContext) error
+
+// SetShippingQueryProcessor 设置收货查询处理器
+func (b *Bot) SetShippingQueryProcessor(fn ShippingQueryProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtShippingQuery, fn)
+}
+
+// PreCheckoutQueryContext 预结帐查询上下文
+type PreCheckoutQueryContext struct {
+	*telegram.API
+	*telegram.PreCheckoutQuery
+
+	ctx *Context // 所属的通用上下文
+}
+
+// PreCheckoutQueryProcessorFunc 预结帐查询处理函数
+type PreCheckoutQueryProcessorFunc func(c *PreCheckoutQueryContext) error
+
+// SetPreCheckoutQueryProcessor 设置预结帐查询处理器
+func (b *Bot) SetPreCheckoutQueryProcessor(fn PreCheckoutQueryProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtPreCheckoutQuery, fn)
+}
+
+// PollContext 投票状态上下文
+type PollContext struct {
+	*telegram.API
+	*telegram.Poll
+
+	ctx *Context // 所属的通用上下文
+}
+
+// PollProcessorFunc 投票状态处理函数
+type PollProcessorFunc func(c *PollContext) error
+
+// SetPollProcessor 设置投票状态处理器
+func (b *Bot) SetPollProcessor(fn PollProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtPoll, fn)
+}
+
+// PollAnswerContext 投票答案上下文
+type PollAnswerContext struct {
+	*telegram.API
+	*telegram.PollAnswer
+
+	ctx *Context // 所属的通用上下文
+}
+
+// PollAnswerProcessorFunc 投票答案处理函数
+type PollAnswerProcessorFunc func(c *PollAnswerContext) error
+
+// SetPollAnswerProcessor 设置投票答案处理器
+func (b *Bot) SetPollAnswerProcessor(fn PollAnswerProcessorFunc) {
+	b.setUpdateProcessor(telegram.UpdateTypeAtPollAnswer, fn)
+}
+
+// setUpdateProcessor 设置指定更新类型的处理器
+func (b *Bot) setUpdateProcessor(typeS string, fn interface{}) {
+	if b.updateProcessorFunc == nil {
+		b.updateProcessorFunc = map[string]interface{}{}
+	}
+
+	b.updateProcessorFunc[typeS] = fn
 }
 
 // AddActiveProcessor 添加主动处理器
@@ -464,24 +705,76 @@ func (b *Bot) AddActiveProcessor(activeProcessorFunc ActiveProcessorFunc) {
 // Run 运行 bot
 // 只有再拥有 Processor 时才会正常阻塞
 func (b *Bot) Run() error {
-	_, err := b.API.GetMe() // check api
+	return b.RunContext(context.Background())
+}
+
+// RunContext 使用指定上下文运行 bot
+// ctx 被取消后停止长轮询或 webhook 服务并返回 ctx.Err()，调用 Shutdown 后立即返回 ErrBotClosed
+func (b *Bot) RunContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	engineDone := make(chan struct{})
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+		b.mu.Unlock()
+		return ErrBotClosed
+	default:
+	}
+	b.stop = cancel
+	b.engineDone = engineDone
+	b.mu.Unlock()
+
+	me, err := b.API.WithContext(ctx).GetMe() // check api
 	if err != nil {
+		close(engineDone)
 		return fmt.Errorf("check api call failed: %w", err)
 	}
+	b.username = me.Username
+
+	if err := b.syncCommands(ctx); err != nil { // 不影响运行
+		b.handleUpdateError(nil, fmt.Errorf("sync commands: %w", err))
+	}
+
+	d := newDispatcher(b.workers, b.queueSize, b.safeKeyFunc, b.processUpdate)
+	b.mu.Lock()
+	b.dispatcher = d
+	b.mu.Unlock()
+	b.reopenAlbums()
 
 	if (b.webHookEngine) != nil { // 为了和主动处理器行为一致
 		go func() {
-			b.checkTask()
-			if err := b.webHookEngine(); err != nil {
-				b.err <- err
+			defer close(engineDone)
+			defer d.close() // 不再接收更新，队列中剩余的更新仍会处理完
+			defer b.flushAlbums()
+			b.checkTask(ctx)
+			if err := b.webHookEngine(ctx); err != nil {
+				b.handleError(err)
 			}
 		}()
 	} else {
 		if err := b.DeleteWebhook(nil); err != nil {
+			d.close()
+			close(engineDone)
 			return err
 		}
-		b.checkTask()
-		go b.initiativeEngine()
+		if b.offsets != nil {
+			offset, err := b.offsets.load(b.MsgOffset)
+			if err != nil {
+				d.close()
+				close(engineDone)
+				return err
+			}
+			b.MsgOffset = offset
+		}
+		b.checkTask(ctx)
+		go func() {
+			defer close(engineDone)
+			defer d.close()
+			defer b.flushAlbums()
+			b.initiativeEngine(ctx)
+		}()
 	}
 
 loop:
@@ -491,114 +784,575 @@ loop:
 			return err
 		case <-b.done:
 			break loop
+		case <-ctx.Done():
+			select {
+			case <-b.closed:
+				return ErrBotClosed
+			default:
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// Shutdown 优雅关闭 bot
+// 停止获取新的更新，等待正在执行的处理器结束，然后向 telegram 提交最后处理的消息偏移量（仅长轮询模式），
+// 以免重启后丢失或重复处理更新。ctx 到期时不再等待并返回 ctx.Err()，此时不会提交偏移量，未完成的更新会被重新投递
+func (b *Bot) Shutdown(ctx context.Context) error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+
+	b.mu.Lock()
+	stop, engineDone := b.stop, b.engineDone
+	b.mu.Unlock()
+	if stop == nil { // 未运行
+		return nil
+	}
+	stop()
+
+	select {
+	case <-engineDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mu.Lock()
+	d := b.dispatcher // 引擎退出后调度器不会再变化
+	b.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		if d != nil {
+			d.wait()
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	offset := b.MsgOffset
+	if b.offsets != nil {
+		offset, _ = b.offsets.offset()
+	}
+	if b.webHookEngine == nil && offset != 0 {
+		// 携带偏移量请求一次即可确认之前的所有更新，本次返回的更新不会被确认
+		if _, err := b.API.WithContext(ctx).GetUpdates(offset, 1, 0); err != nil {
+			return fmt.Errorf("commit offset failed: %w", err)
 		}
 	}
 
 	return nil
 }
 
-// handleError 处理错误
+// handleError 处理导致 bot 无法继续运行的错误（Run 将返回该错误）
 func (b *Bot) handleError(err error) {
 	if err != nil {
-		b.err <- err
+		select {
+		case b.err <- err:
+		default: // 已有错误等待返回或 Run 已退出
+		}
+	}
+}
+
+// finish 通知 Run 所有任务已完成
+func (b *Bot) finish() {
+	select {
+	case b.done <- struct{}{}:
+	default:
 	}
 }
 
 // checkTask 检查任务再合适的地方结束
-func (b *Bot) checkTask() {
+func (b *Bot) checkTask(ctx context.Context) {
 	totalNumberOfActiveAndPassive := 0
 	cleanActiveAndPassiveCh := make(chan struct{})
 	for k, vFn := range b.activeProcessorFunc {
 		totalNumberOfActiveAndPassive++
 		go func(index int, fn ActiveProcessorFunc) {
-			if err := fn(b.API); err != nil {
+			defer func() {
+				cleanActiveAndPassiveCh <- struct{}{}
+			}()
+			defer b.recoverPanic(nil)
+
+			if err := fn(b.API.WithContext(ctx)); err != nil {
 				humanRead := index + 1 // 从 0开始的改成人类可读数
-				b.handleError(fmt.Errorf("the %d AddActiveProcessor: %w", humanRead, err))
+				b.handleUpdateError(nil, fmt.Errorf("the %d AddActiveProcessor: %w", humanRead, err))
 			}
-			cleanActiveAndPassiveCh <- struct{}{}
 		}(k, vFn)
 	}
 
-	if len(b.commands) != 0 || b.defaultCommand != nil || b.defaultMessageProcessorFunc != nil || len(b.specifiedTypeMessageProcessorFunc) != 0 || b.inlineQueryProcessorFunc != nil { // 统计被动
+	if !b.messageHandlers.empty() || !b.inlineHandlers.empty() || len(b.updateProcessorFunc) != 0 || len(b.conversations) != 0 || len(b.callbacks) != 0 || b.albums.fn != nil { // 统计被动
 		totalNumberOfActiveAndPassive++
 	}
 
 	go func() {
 		num := 0
 		if num >= totalNumberOfActiveAndPassive {
-			b.done <- struct{}{}
+			b.finish()
 		}
 		for range cleanActiveAndPassiveCh {
 			num++
 			if num >= totalNumberOfActiveAndPassive {
-				b.done <- struct{}{}
+				b.finish()
 			}
 		}
 	}()
 }
 
 // initiativeEngine 核心调度
-func (b *Bot) initiativeEngine() {
+func (b *Bot) initiativeEngine(ctx context.Context) {
+	api := b.API.WithContext(ctx)
+	allowedUpdates := b.AllowedUpdates()
 	for {
-		updates, err := b.API.GetUpdates(b.MsgOffset, 1, b.timeout)
+		// 设置了偏移量存储时只确认已处理完的更新，已提交给调度器但未处理完的更新会再次返回，跳过即可
+		offset, changed := b.MsgOffset, (<-chan struct{})(nil)
+		if b.offsets != nil {
+			offset, changed = b.offsets.offset()
+		}
+
+		updates, err := api.GetUpdates(offset, b.limit, b.timeout, allowedUpdates...)
 		if err != nil {
+			if ctx.Err() != nil { // 已停止
+				return
+			}
 			b.handleError(err)
 			return
 		}
-		if len(updates) == 0 {
-			continue
+
+		received := 0
+		for i := range updates {
+			update := &updates[i]
+			if b.offsets != nil && !b.offsets.receive(update.UpdateID) { // 正在处理
+				continue
+			}
+			received++
+
+			if err := b.dispatch(ctx, update, nil); err != nil { // 已停止，未提交的更新不记录偏移量
+				return
+			}
+
+			b.MsgOffset = update.UpdateID + 1 // 记录消息偏量
 		}
-		update := updates[0]
 
-		b.MsgOffset = update.UpdateID + 1 // 记录消息偏量
+		if b.offsets != nil && received == 0 && len(updates) != 0 { // 返回的更新都在处理中，等待偏移量前进后再请求
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
 
-		switch {
-		default:
-			continue
-		case update.Message != nil:
-			go b.handleReceivedMessages(update.Message)
-		// case update.EditedMessage != nil:
-		// case update.ChannelPost != nil:
-		// case update.EditedChannelPost != nil:
-		case update.InlineQuery != nil:
-			go b.handleInlineQuery(update.InlineQuery)
+// AllowedUpdates 获取订阅的更新类型
+// 未通过 BotOptional.AllowedUpdates 指定时根据已注册的处理器生成，没有任何处理器时返回 nil（不限制）
+func (b *Bot) AllowedUpdates() []string {
+	if len(b.allowedUpdates) != 0 {
+		return b.allowedUpdates
+	}
 
-			// case update.ChosenInlineResult != nil:
-			// case update.CallbackQuery != nil:
-			// case update.ShippingQuery != nil:
-			// case update.PreCheckoutQuery != nil:
-			// case update.Poll != nil:
-			// case update.PollAnswer != nil:
+	var result []string
+	if !b.messageHandlers.empty() || len(b.conversations) != 0 || b.albums.fn != nil {
+		result = append(result, telegram.UpdateTypeAtMessage)
+	}
+	if !b.inlineHandlers.empty() {
+		result = append(result, telegram.UpdateTypeAtInlineQuery)
+	}
+	for _, typeS := range []string{
+		telegram.UpdateTypeAtEditedMessage,
+		telegram.UpdateTypeAtChannelPost,
+		telegram.UpdateTypeAtEditedChannelPost,
+		telegram.UpdateTypeAtChosenInlineResult,
+		telegram.UpdateTypeAtCallbackQuery,
+		telegram.UpdateTypeAtShippingQuery,
+		telegram.UpdateTypeAtPreCheckoutQuery,
+		telegram.UpdateTypeAtPoll,
+		telegram.UpdateTypeAtPollAnswer,
+	} {
+		if _, ok := b.updateProcessorFunc[typeS]; ok || (typeS == telegram.UpdateTypeAtCallbackQuery && (len(b.conversations) != 0 || len(b.callbacks) != 0)) {
+			result = append(result, typeS)
 		}
 	}
+
+	return result
 }
 
-// handleInlineQuery 处理内联查询
-func (b *Bot) handleInlineQuery(query *telegram.InlineQuery) {
-	if query == nil {
-		return
+// dispatch 将更新提交给调度器，队列已满时阻塞直到有空位或 ctx 结束，Shutdown 会等待已提交的更新处理完毕
+// reply 不为 nil 时处理器可以通过 Respond 将 API 调用写入 webhook 响应
+func (b *Bot) dispatch(ctx context.Context, update *telegram.Update, reply *webhookReply) error {
+	b.mu.Lock()
+	d := b.dispatcher
+	b.mu.Unlock()
+	if d == nil { // 未运行
+		return ErrBotClosed
 	}
 
-	if b.inlineQueryProcessorFunc != nil {
-		err := b.inlineQueryProcessorFunc(&InlineQueryContext{
-			API:         b.API,
-			InlineQuery: query,
-		})
-		if err != nil {
-			b.handleError(err)
+	if b.collectAlbum(update) { // 相册在等待结束后作为一个任务提交
+		if reply != nil {
+			reply.finish()
 		}
+		return nil
 	}
+	return d.submit(ctx, &job{update: update, reply: reply})
 }
 
-// handleReceivedMessages 处理接收消息
-func (b *Bot) handleReceivedMessages(message *telegram.Message) {
-	if message == nil {
+// processUpdate 在工作协程中处理更新
+func (b *Bot) processUpdate(j *job) {
+	if j.reply != nil {
+		defer j.reply.finish()
+	}
+	defer b.finishOffset(j)
+	defer b.recoverPanic(j.update)
+
+	if j.migration {
+		b.handleChatMigrated(j.update)
 		return
 	}
+	if j.album != nil {
+		var album []*telegram.Update
+		for _, update := range j.album {
+			if !b.alreadyHandled(update) {
+				album = append(album, update)
+			}
+		}
+		if len(album) != 0 {
+			b.handleAlbum(album)
+		}
+		return
+	}
+	if b.alreadyHandled(j.update) {
+		return
+	}
+	b.handleUpdate(j.update, j.reply)
+}
+
+// handleUpdate 按更新类型分发到对应的处理器
+func (b *Bot) handleUpdate(update *telegram.Update, reply *webhookReply) {
+	ctx := b.newContext(update)
+	ctx.reply = reply
+	b.runContext(ctx, func() {
+		defer b.autoAnswerCallbackQuery(ctx)
+		b.routeUpdate(ctx)
+	})
+}
+
+// routeUpdate 将上下文交给会话或对应类型的处理器
+func (b *Bot) routeUpdate(ctx *Context) {
+	update := ctx.Update
+	typeS := getUpdateType(update)
+	if typeS == telegram.UpdateTypeAtMessage || typeS == telegram.UpdateTypeAtCallbackQuery {
+		if b.handleConversation(ctx) {
+			return
+		}
+	}
+
+	switch typeS {
+	case telegram.UpdateTypeAtMessage:
+		b.handleReceivedMessages(ctx)
+	case telegram.UpdateTypeAtInlineQuery:
+		b.handle(&b.inlineHandlers, ctx)
+	case telegram.UpdateTypeAtCallbackQuery:
+		b.handleCallbackQuery(ctx)
+	default:
+		fn := b.updateProcessor(typeS)
+		if fn == nil {
+			return
+		}
+		if err := b.invoke(ctx, fn); err != nil && !errors.Is(err, ErrContinue) {
+			b.handleUpdateError(update, fmt.Errorf("%s processor: %w", typeS, err))
+		}
+	}
+}
+
+// runContext 执行 fn，正常返回后保存处理器对会话状态与会话数据的修改
+// fn 发生 panic 时只释放会话状态与会话数据的锁，丢弃处理到一半的修改，panic 继续交给 recoverPanic
+func (b *Bot) runContext(ctx *Context, fn func()) {
+	completed := false
+	defer func() {
+		if !completed {
+			b.releaseSession(ctx)
+			b.releaseConversation(ctx)
+		}
+	}()
+
+	fn()
+	completed = true
+	b.finishContext(ctx)
+}
+
+// finishContext 处理结束后保存处理器对会话状态与会话数据的修改
+func (b *Bot) finishContext(ctx *Context) {
+	if err := b.saveConversation(ctx); err != nil {
+		b.handleUpdateError(ctx.Update, fmt.Errorf("save conversation: %w", err))
+	}
+	if err := b.saveSession(ctx); err != nil {
+		b.handleUpdateError(ctx.Update, fmt.Errorf("save session: %w", err))
+	}
+}
+
+// getUpdateType 获取更新类型（telegram.UpdateTypeAt*），未知类型返回空字符串
+func getUpdateType(update *telegram.Update) string {
+	switch {
+	case update.Message != nil:
+		return telegram.UpdateTypeAtMessage
+	case update.EditedMessage != nil:
+		return telegram.UpdateTypeAtEditedMessage
+	case update.ChannelPost != nil:
+		return telegram.UpdateTypeAtChannelPost
+	case update.EditedChannelPost != nil:
+		return telegram.UpdateTypeAtEditedChannelPost
+	case update.InlineQuery != nil:
+		return telegram.UpdateTypeAtInlineQuery
+	case update.ChosenInlineResult != nil:
+		return telegram.UpdateTypeAtChosenInlineResult
+	case update.CallbackQuery != nil:
+		return telegram.UpdateTypeAtCallbackQuery
+	case update.ShippingQuery != nil:
+		return telegram.UpdateTypeAtShippingQuery
+	case update.PreCheckoutQuery != nil:
+		return telegram.UpdateTypeAtPreCheckoutQuery
+	case update.Poll != nil:
+		return telegram.UpdateTypeAtPoll
+	case update.PollAnswer != nil:
+		return telegram.UpdateTypeAtPollAnswer
+	}
+	return ""
+}
+
+// updateProcessor 将指定更新类型的处理器转换为通用处理器，未设置时返回 nil
+func (b *Bot) updateProcessor(typeS string) MessageProcessorFunc {
+	switch fn := b.updateProcessorFunc[typeS].(type) {
+	case EditedMessageProcessorFunc:
+		return func(c *Context) error {
+			return fn(&EditedMessageContext{Context: c})
+		}
+	case ChannelPostProcessorFunc:
+		return func(c *Context) error {
+			return fn(&ChannelPostContext{Context: c})
+		}
+	case EditedChannelPostProcessorFunc:
+		return func(c *Context) error {
+			return fn(&EditedChannelPostContext{Context: c})
+		}
+	case ChosenInlineResultProcessorFunc:
+		return func(c *Context) error {
+			return fn(&ChosenInlineResultContext{API: c.API, ChosenInlineResult: c.Update.ChosenInlineResult, ctx: c})
+		}
+	case CallbackQueryProcessorFunc:
+		return func(c *Context) error {
+			return fn(&CallbackQueryContext{API: c.API, CallbackQuery: c.Update.CallbackQuery, ctx: c})
+		}
+	case ShippingQueryProcessorFunc:
+		return func(c *Context) error {
+			return fn(&ShippingQueryContext{API: c.API, ShippingQuery: c.Update.ShippingQuery, ctx: c})
+		}
+	case PreCheckoutQueryProcessorFunc:
+		return func(c *Context) error {
+			return fn(&PreCheckoutQueryContext{API: c.API, PreCheckoutQuery: c.Update.PreCheckoutQuery, ctx: c})
+		}
+	case PollProcessorFunc:
+		return func(c *Context) error {
+			return fn(&PollContext{API: c.API, Poll: c.Update.Poll, ctx: c})
+		}
+	case PollAnswerProcessorFunc:
+		return func(c *Context) error {
+			return fn(&PollAnswerContext{API: c.API, PollAnswer: c.Update.PollAnswer, ctx: c})
+		}
+	}
+	return nil
+}
+
+// typedMessageProcessor 将指定类型的消息处理器转换为通用处理器，fn 为 nil 时返回 nil
+func typedMessageProcessor(fn interface{}) MessageProcessorFunc {
+	switch fn := fn.(type) {
+	case TextMessageProcessorFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtText", fn(&TextMessageContext{
+				MessageContextBase: newMessageContextBase(c),
+				ReplyToMessage:     c.Message.ReplyToMessage,
+				Text:               c.Message.Text,
+			}))
+		}
+	case ProcessorAtPhotoFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtPhoto", fn(&MessageContextAtPhoto{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+				Photo:                     c.Message.Photo,
+				Caption:                   c.Message.Caption,
+			}))
+		}
+	case ProcessorAtVoiceFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtVoice", fn(&MessageContextAtVoice{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+				Voice:                     c.Message.Voice,
+			}))
+		}
+	case ProcessorAtAudioFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtAudio", fn(&MessageContextAtAudio{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				Audio:   c.Message.Audio,
+				Caption: c.Message.Caption,
+			}))
+		}
+	case ProcessorAtVideoFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtVideo", fn(&MessageContextAtVideo{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				Video:   c.Message.Video,
+				Caption: c.Message.Caption,
+			}))
+		}
+	case ProcessorAtAnimationFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtAnimation", fn(&MessageContextAtAnimation{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				Animation: c.Message.Animation,
+				Document:  c.Message.Document,
+			}))
+		}
+	case ProcessorAtDocumentFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtDocument", fn(&MessageContextAtDocument{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				Document: c.Message.Document,
+				Caption:  c.Message.Caption,
+			}))
+		}
+	case ProcessorAtStickerFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtSticker", fn(&MessageContextAtSticker{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				Sticker: c.Message.Sticker,
+			}))
+		}
+	case ProcessorAtVideoNoteFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtVideoNote", fn(&MessageContextAtVideoNote{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
+
+				VideoNote: c.Message.VideoNote,
+			}))
+		}
+	case ProcessorAtContactFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtContact", fn(&MessageContextAtContact{
+				MessageContextBase: newMessageContextBase(c),
+
+				Contact: c.Message.Contact,
+			}))
+		}
+	case ProcessorAtDiceFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtDice", fn(&MessageContextAtDice{
+				MessageContextBase: newMessageContextBase(c),
+
+				Dice: c.Message.Dice,
+			}))
+		}
+	case ProcessorAtGameFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtGame", fn(&MessageContextAtGame{
+				MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: newMessageContextBase(c)},
 
+				Game: c.Message.Game,
+			}))
+		}
+	case ProcessorAtPollFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtPoll", fn(&MessageContextAtPoll{
+				MessageContextBase: newMessageContextBase(c),
+
+				Poll: c.Message.Poll,
+			}))
+		}
+	case ProcessorAtVenueFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtVenue", fn(&MessageContextAtVenue{
+				MessageContextBase: newMessageContextBase(c),
+
+				Venue: c.Message.Venue,
+			}))
+		}
+	case ProcessorAtLocationFunc:
+		return func(c *Context) error {
+			return labelError("SetMessageProcessorAtLocation", fn(&MessageContextAtLocation{
+				MessageContextBase: newMessageContextBase(c),
+
+				Location: c.Message.Location,
+			}))
+		}
+	}
+	return serviceMessageProcessor(fn)
+}
+
+// labelError 为处理器返回的错误添加来源标记
+func labelError(label string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	return nil
+}
+
+// newMessageContextBase 根据上下文创建基础消息上下文
+func newMessageContextBase(c *Context) MessageContextBase {
+	return MessageContextBase{
+		API:       c.API,
+		MessageID: c.Message.MessageID,
+		Form:      c.Message.From,
+		Chat:      c.Message.Chat,
+
+		ForwardFrom:          c.Message.ForwardFrom,
+		ForwardFromChat:      c.Message.ForwardFromChat,
+		ForwardFromMessageID: c.Message.ForwardFromMessageID,
+		ForwardSignature:     c.Message.ForwardSignature,
+		ForwardSenderName:    c.Message.ForwardSenderName,
+		ForwardDate:          c.Message.ForwardDate,
+		ViaBot:               c.Message.ViaBot,
+
+		ctx: c,
+	}
+}
+
+// getUpdateMessage 获取更新中的消息（新消息、已编辑消息、频道帖子、已编辑频道帖子），没有时返回 nil
+func getUpdateMessage(update *telegram.Update) *telegram.Message {
+	switch {
+	case update.Message != nil:
+		return update.Message
+	case update.EditedMessage != nil:
+		return update.EditedMessage
+	case update.ChannelPost != nil:
+		return update.ChannelPost
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost
+	}
+	return nil
+}
+
+// newContext 根据更新创建上下文
+func (b *Bot) newContext(update *telegram.Update) *Context {
 	ctx := &Context{
-		Message: message,
 		API:     b.API,
+		Update:  update,
+		Message: getUpdateMessage(update),
+		bot:     b,
+	}
+
+	message := ctx.Message
+	if message == nil {
+		return ctx
 	}
 
 	// 消息类型判断
@@ -633,234 +1387,28 @@ func (b *Bot) handleReceivedMessages(message *telegram.Message) {
 		ctx.MessageType = ContextTypeAtVenue
 	case message.Location != nil:
 		ctx.MessageType = ContextTypeAtLocation
-	}
-
-	go func() {
-		for _, messageEntity := range message.Entities { // 可能是 bot
-			if messageEntity.Type == telegram.MessageEntityAtBotCommand {
-				/*命令格式
-				/foo
-				/foo bar
-				*/
-
-				sp := strings.SplitN(message.Text, " ", 2)
-				cmd := sp[0]
-				fn, ok := b.commands[cmd]
-				if !ok {
-					if b.defaultCommand != nil { // 默认命令处理器
-						if err := b.defaultCommand(ctx); err != nil {
-							b.handleError(err)
-							return
-						}
-					}
-
-					break // 默认命令处理器不存在则转为消息处理器进行处理
-				}
-
-				text := ""
-				if len(sp) == 2 {
-					text = sp[1]
-				}
-				ctx.Message.Text = text         // 抹除命令
-				if err := fn(ctx); err != nil { // 命令处理器
-					b.handleError(err)
-					return
-				}
-			}
+	case len(message.NewChatMembers) != 0:
+		ctx.MessageType = ContextTypeAtNewChatMembers
+	case message.LeftChatMember != nil:
+		ctx.MessageType = ContextTypeAtLeftChatMember
+	case message.EwChatTitle != "":
+		ctx.MessageType = ContextTypeAtNewChatTitle
+	case len(message.NewChatPhoto) != 0:
+		ctx.MessageType = ContextTypeAtNewChatPhoto
+	case message.DeleteChatPhoto:
+		ctx.MessageType = ContextTypeAtDeleteChatPhoto
+	case message.GroupChatCreated:
+		ctx.MessageType = ContextTypeAtGroupChatCreated
+	case message.PinnedMessage != nil:
+		ctx.MessageType = ContextTypeAtPinnedMessage
+	case message.MigrateToChatID != 0:
+		ctx.MessageType = ContextTypeAtMigrateToChat
+		if message.Chat != nil {
+			ctx.migrationKnown = !b.recordMigration(message.Chat.ID, message.MigrateToChatID)
 		}
-		if _, ok := b.specifiedTypeMessageProcessorFunc[ctx.MessageType]; ok {
-			base := MessageContextBase{
-				API:       ctx.API,
-				MessageID: ctx.Message.MessageID,
-				Form:      ctx.Message.From,
-				Chat:      ctx.Message.Chat,
-
-				ForwardFrom:          ctx.Message.ForwardFrom,
-				ForwardFromChat:      ctx.Message.ForwardFromChat,
-				ForwardFromMessageID: ctx.Message.ForwardFromMessageID,
-				ForwardSignature:     ctx.Message.ForwardSignature,
-				ForwardSenderName:    ctx.Message.ForwardSenderName,
-				ForwardDate:          ctx.Message.ForwardDate,
-				ViaBot:               ctx.Message.ViaBot,
-			}
+	case message.MigrateFromChatID != 0:
+		ctx.MessageType = ContextTypeAtMigrateFromChat
+	}
 
-			switch ctx.MessageType {
-			case ContextTypeAtText:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(TextMessageProcessorFunc)
-				c := &TextMessageContext{
-					MessageContextBase: base,
-					ReplyToMessage:     ctx.Message.ReplyToMessage,
-					Text:               ctx.Message.Text,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtText: %w", err))
-					return
-				}
-			case ContextTypeAtPhoto:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtPhotoFunc)
-				c := &MessageContextAtPhoto{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-					Photo:                     ctx.Message.Photo,
-					Caption:                   ctx.Message.Caption,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtPhoto: %w", err))
-					return
-				}
-			case ContextTypeAtVoice:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtVoiceFunc)
-				c := &MessageContextAtVoice{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-					Voice:                     ctx.Message.Voice,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtVoice: %w", err))
-					return
-				}
-			case ContextTypeAtAudio:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtAudioFunc)
-				c := &MessageContextAtAudio{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Audio:   ctx.Message.Audio,
-					Caption: ctx.Message.Caption,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtAudio: %w", err))
-					return
-				}
-			case ContextTypeAtVideo:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtVideoFunc)
-				c := &MessageContextAtVideo{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Video:   ctx.Message.Video,
-					Caption: ctx.Message.Caption,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtVideo: %w", err))
-					return
-				}
-			case ContextTypeAtAnimation:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtAnimationFunc)
-				c := &MessageContextAtAnimation{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Animation: ctx.Message.Animation,
-					Document:  ctx.Message.Document,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtAnimation: %w", err))
-					return
-				}
-			case ContextTypeAtDocument:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtDocumentFunc)
-				c := &MessageContextAtDocument{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Document: ctx.Message.Document,
-					Caption:  ctx.Message.Caption,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtDocument: %w", err))
-					return
-				}
-			case ContextTypeAtSticker:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtStickerFunc)
-				c := &MessageContextAtSticker{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Sticker: ctx.Message.Sticker,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtSticker: %w", err))
-					return
-				}
-			case ContextTypeAtVideoNote:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtVideoNoteFunc)
-				c := &MessageContextAtVideoNote{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					VideoNote: ctx.Message.VideoNote,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtVideoNote: %w", err))
-					return
-				}
-			case ContextTypeAtContact:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtContactFunc)
-				c := &MessageContextAtContact{
-					MessageContextBase: base,
-
-					Contact: ctx.Message.Contact,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtContact: %w", err))
-					return
-				}
-			case ContextTypeAtDice:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtDiceFunc)
-				c := &MessageContextAtDice{
-					MessageContextBase: base,
-
-					Dice: ctx.Message.Dice,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtDice: %w", err))
-					return
-				}
-			case ContextTypeAtGame:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtGameFunc)
-				c := &MessageContextAtGame{
-					MessageContextIncludeFile: MessageContextIncludeFile{MessageContextBase: base},
-
-					Game: ctx.Message.Game,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtGame: %w", err))
-					return
-				}
-			case ContextTypeAtPoll:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtPollFunc)
-				c := &MessageContextAtPoll{
-					MessageContextBase: base,
-
-					Poll: ctx.Message.Poll,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtPoll: %w", err))
-					return
-				}
-			case ContextTypeAtVenue:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtVenueFunc)
-				c := &MessageContextAtVenue{
-					MessageContextBase: base,
-
-					Venue: ctx.Message.Venue,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtVenue: %w", err))
-					return
-				}
-			case ContextTypeAtLocation:
-				fn := b.specifiedTypeMessageProcessorFunc[ctx.MessageType].(ProcessorAtLocationFunc)
-				c := &MessageContextAtLocation{
-					MessageContextBase: base,
-
-					Location: ctx.Message.Location,
-				}
-				if err := fn(c); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcessorAtLocation: %w", err))
-					return
-				}
-			}
-		} else {
-			if b.defaultMessageProcessorFunc != nil { // 消息处理器
-				if err := b.defaultMessageProcessorFunc(ctx); err != nil {
-					b.handleError(fmt.Errorf("SetMessageProcesso: %w", err))
-					return
-				}
-			}
-		}
-	}()
+	return ctx
 }
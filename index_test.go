@@ -0,0 +1,230 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// commitPolls 获取 Shutdown 提交偏移量的请求（limit 为1）
+func commitPolls(f *fakeTelegram) []fakePoll {
+	var result []fakePoll
+	for _, poll := range f.Polls() {
+		if poll.Limit == 1 {
+			result = append(result, poll)
+		}
+	}
+	return result
+}
+
+func TestBot_ShutdownDrains(t *testing.T) {
+	chat := &telegram.Chat{ID: 100}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, "a"),
+		textUpdate(2, chat, nil, "b"),
+	)
+	b := f.newBot(&BotOptional{Workers: 1})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished int32
+	b.SetMessageProcessor(func(c *Context) error {
+		if c.Update.UpdateID == 2 {
+			close(started)
+			<-release
+			atomic.StoreInt32(&finished, 1)
+		}
+		return nil
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待处理器开始超时")
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- b.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("处理器结束前 Shutdown 不应返回: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("Shutdown 返回时处理器未结束")
+	}
+	if err := <-runErr; err != ErrBotClosed {
+		t.Fatal(err)
+	}
+
+	polls := f.Polls()
+	if last := polls[len(polls)-1]; last.Limit != 1 || last.Offset != 3 {
+		t.Fatalf("最后一次 getUpdates %+v 与预期的偏移量提交不一致", last)
+	}
+}
+
+func TestBot_ShutdownTimeout(t *testing.T) {
+	f := newFakeTelegram(t, textUpdate(1, &telegram.Chat{ID: 100}, nil, "a"))
+	b := f.newBot(&BotOptional{Workers: 1})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	b.SetMessageProcessor(func(c *Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待处理器开始超时")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown 超时返回 %v", err)
+	}
+	if err := <-runErr; err != ErrBotClosed {
+		t.Fatal(err)
+	}
+	if polls := commitPolls(f); len(polls) != 0 {
+		t.Fatalf("超时后不应提交偏移量: %+v", polls)
+	}
+}
+
+func TestBot_RunContextCancel(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := f.newBot(nil) // 长轮询，没有更新时 getUpdates 一直阻塞
+	b.SetMessageProcessor(func(c *Context) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.RunContext(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Polls()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("等待 getUpdates 超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != context.Canceled {
+			t.Fatalf("RunContext 返回 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后正在进行的 getUpdates 没有被中断")
+	}
+}
+
+func TestAPI_WithContextDeadline(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := f.newBot(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := b.API.WithContext(ctx).GetUpdates(0, 1, 30) // 没有更新时阻塞
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("请求返回 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("请求在 %v 后才返回", elapsed)
+	}
+
+	// 原 API 不受影响
+	if _, err := b.API.GetMe(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// firstPoll 等待并获取第一次 getUpdates 请求
+func firstPoll(t *testing.T, f *fakeTelegram) fakePoll {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if polls := f.Polls(); len(polls) != 0 {
+			return polls[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待 getUpdates 超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBot_PollLimit(t *testing.T) {
+	for _, c := range []struct {
+		limit uint
+		want  uint
+	}{
+		{0, 100},
+		{10, 10},
+		{100, 100},
+		{500, 100},
+	} {
+		f := newFakeTelegram(t)
+		b := f.newBot(&BotOptional{Limit: c.limit})
+		b.SetMessageProcessor(func(c *Context) error { return nil })
+		runBot(t, b)
+
+		if poll := firstPoll(t, f); poll.Limit != c.want {
+			t.Fatalf("Limit 为 %d 时 getUpdates 的 limit %d 与预期 %d 不一致", c.limit, poll.Limit, c.want)
+		}
+	}
+}
+
+func TestBot_PollAllowedUpdates(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := f.newBot(&BotOptional{})
+	b.SetMessageProcessor(func(c *Context) error { return nil })
+	b.HandleInlineQuery(func(c *InlineQueryContext) error { return nil })
+	b.AddCallback("vote", func(c *CallbackQueryContext) error { return nil })
+	b.SetPollAnswerProcessor(func(c *PollAnswerContext) error { return nil })
+	runBot(t, b)
+
+	want := []string{telegram.UpdateTypeAtMessage, telegram.UpdateTypeAtInlineQuery, telegram.UpdateTypeAtCallbackQuery, telegram.UpdateTypeAtPollAnswer}
+	if poll := firstPoll(t, f); !reflect.DeepEqual(poll.AllowedUpdates, want) {
+		t.Fatalf("allowed_updates %v 与预期 %v 不一致", poll.AllowedUpdates, want)
+	}
+
+	// 指定 AllowedUpdates 时不再根据处理器生成
+	f = newFakeTelegram(t)
+	want = []string{telegram.UpdateTypeAtMessage, telegram.UpdateTypeAtShippingQuery}
+	b = f.newBot(&BotOptional{AllowedUpdates: want})
+	b.SetMessageProcessor(func(c *Context) error { return nil })
+	runBot(t, b)
+	if poll := firstPoll(t, f); !reflect.DeepEqual(poll.AllowedUpdates, want) {
+		t.Fatalf("allowed_updates %v 与预期 %v 不一致", poll.AllowedUpdates, want)
+	}
+}
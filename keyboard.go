@@ -0,0 +1,188 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// InlineKeyboard 嵌入式键盘构建器
+//
+//	kb := NewInlineKeyboard().Columns(2).
+//		Callback("是", "confirm:1").Callback("否", "confirm:0").
+//		Row().URL("帮助", "https://example.com")
+type InlineKeyboard struct {
+	rows    [][]telegram.InlineKeyboardButton
+	columns int   // 每行最多的按钮数，超出时自动换行，为0时不自动换行
+	err     error // 添加按钮时的第一个错误
+}
+
+// NewInlineKeyboard 新建嵌入式键盘构建器
+func NewInlineKeyboard() *InlineKeyboard {
+	return &InlineKeyboard{}
+}
+
+// Columns 设置每行最多的按钮数，之后添加的按钮超出时自动换行
+func (k *InlineKeyboard) Columns(n int) *InlineKeyboard {
+	k.columns = n
+	return k
+}
+
+// Row 开始新的一行（当前行为空时不会产生空行）
+func (k *InlineKeyboard) Row() *InlineKeyboard {
+	if len(k.rows) != 0 && len(k.rows[len(k.rows)-1]) != 0 {
+		k.rows = append(k.rows, nil)
+	}
+	return k
+}
+
+// Button 添加按钮
+func (k *InlineKeyboard) Button(btn telegram.InlineKeyboardButton) *InlineKeyboard {
+	if btn.Text == "" && k.err == nil {
+		k.err = errors.New("tgbot: keyboard button text is empty")
+	}
+
+	if len(k.rows) == 0 || (k.columns > 0 && len(k.rows[len(k.rows)-1]) >= k.columns) {
+		k.rows = append(k.rows, nil)
+	}
+	k.rows[len(k.rows)-1] = append(k.rows[len(k.rows)-1], btn)
+	return k
+}
+
+// URL 添加链接按钮
+func (k *InlineKeyboard) URL(text string, url string) *InlineKeyboard {
+	return k.Button(telegram.InlineKeyboardButton{Text: text, URL: url})
+}
+
+// Callback 添加回调按钮，data 可以由 Bot.CallbackData 生成，超过 64 字节时 Err 返回错误
+func (k *InlineKeyboard) Callback(text string, data string) *InlineKeyboard {
+	if len(data) > MaxCallbackDataLength && k.err == nil {
+		k.err = fmt.Errorf("button %q: %w", text, ErrCallbackDataTooLong)
+	}
+	return k.Button(telegram.InlineKeyboardButton{Text: text, CallbackData: data})
+}
+
+// SwitchInline 添加切换到内联模式的按钮，用户选择会话后在输入框中填入 bot 用户名与 query
+func (k *InlineKeyboard) SwitchInline(text string, query string) *InlineKeyboard {
+	return k.Button(telegram.InlineKeyboardButton{Text: text, SwitchInlineQuery: query})
+}
+
+// SwitchInlineCurrentChat 添加在当前会话中切换到内联模式的按钮
+func (k *InlineKeyboard) SwitchInlineCurrentChat(text string, query string) *InlineKeyboard {
+	return k.Button(telegram.InlineKeyboardButton{Text: text, SwitchInlineQueryCurrentChat: query})
+}
+
+// Login 添加登录按钮
+func (k *InlineKeyboard) Login(text string, login telegram.LoginURL) *InlineKeyboard {
+	return k.Button(telegram.InlineKeyboardButton{Text: text, LoginURL: &login})
+}
+
+// Pay 添加付款按钮（必须是第一行的第一个按钮）
+func (k *InlineKeyboard) Pay(text string) *InlineKeyboard {
+	return k.Button(telegram.InlineKeyboardButton{Text: text, Pay: true})
+}
+
+// Err 获取添加按钮时的第一个错误
+func (k *InlineKeyboard) Err() error {
+	return k.err
+}
+
+// Markup 生成嵌入式键盘
+func (k *InlineKeyboard) Markup() *telegram.InlineKeyboardMarkup {
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{}}
+	for _, row := range k.rows {
+		if len(row) != 0 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, append([]telegram.InlineKeyboardButton{}, row...))
+		}
+	}
+	return markup
+}
+
+// ReplyKeyboard 回复键盘构建器
+type ReplyKeyboard struct {
+	rows    [][]telegram.KeyboardButton
+	columns int // 每行最多的按钮数，超出时自动换行，为0时不自动换行
+	markup  telegram.ReplyKeyboardMarkup
+}
+
+// NewReplyKeyboard 新建回复键盘构建器
+func NewReplyKeyboard() *ReplyKeyboard {
+	return &ReplyKeyboard{}
+}
+
+// Columns 设置每行最多的按钮数，之后添加的按钮超出时自动换行
+func (k *ReplyKeyboard) Columns(n int) *ReplyKeyboard {
+	k.columns = n
+	return k
+}
+
+// Row 开始新的一行（当前行为空时不会产生空行）
+func (k *ReplyKeyboard) Row() *ReplyKeyboard {
+	if len(k.rows) != 0 && len(k.rows[len(k.rows)-1]) != 0 {
+		k.rows = append(k.rows, nil)
+	}
+	return k
+}
+
+// Button 添加按钮
+func (k *ReplyKeyboard) Button(btn telegram.KeyboardButton) *ReplyKeyboard {
+	if len(k.rows) == 0 || (k.columns > 0 && len(k.rows[len(k.rows)-1]) >= k.columns) {
+		k.rows = append(k.rows, nil)
+	}
+	k.rows[len(k.rows)-1] = append(k.rows[len(k.rows)-1], btn)
+	return k
+}
+
+// Text 添加文本按钮，按下时发送按钮文本
+func (k *ReplyKeyboard) Text(texts ...string) *ReplyKeyboard {
+	for _, text := range texts {
+		k.Button(telegram.KeyboardButton{Text: text})
+	}
+	return k
+}
+
+// Contact 添加发送联系人的按钮
+func (k *ReplyKeyboard) Contact(text string) *ReplyKeyboard {
+	return k.Button(telegram.KeyboardButton{Text: text, RequestContact: true})
+}
+
+// Location 添加发送位置的按钮
+func (k *ReplyKeyboard) Location(text string) *ReplyKeyboard {
+	return k.Button(telegram.KeyboardButton{Text: text, RequestLocation: true})
+}
+
+// Poll 添加创建投票的按钮，pollType 为 quiz、regular 或空（不限）
+func (k *ReplyKeyboard) Poll(text string, pollType string) *ReplyKeyboard {
+	return k.Button(telegram.KeyboardButton{Text: text, RequestPoll: &telegram.KeyboardButtonPollType{Type: pollType}})
+}
+
+// Resize 请求客户端按按钮数量调整键盘高度
+func (k *ReplyKeyboard) Resize() *ReplyKeyboard {
+	k.markup.ResizeKeyboard = true
+	return k
+}
+
+// OneTime 按下按钮后隐藏键盘
+func (k *ReplyKeyboard) OneTime() *ReplyKeyboard {
+	k.markup.OneTimeKeyboard = true
+	return k
+}
+
+// Selective 只向被提及或被回复的用户显示键盘
+func (k *ReplyKeyboard) Selective() *ReplyKeyboard {
+	k.markup.Selective = true
+	return k
+}
+
+// Markup 生成回复键盘
+func (k *ReplyKeyboard) Markup() *telegram.ReplyKeyboardMarkup {
+	markup := k.markup
+	markup.Keyboard = [][]telegram.KeyboardButton{}
+	for _, row := range k.rows {
+		if len(row) != 0 {
+			markup.Keyboard = append(markup.Keyboard, append([]telegram.KeyboardButton{}, row...))
+		}
+	}
+	return &markup
+}
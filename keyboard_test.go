@@ -0,0 +1,78 @@
+package tgbot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestInlineKeyboard(t *testing.T) {
+	kb := NewInlineKeyboard().Columns(2).
+		Callback("a", "1").Callback("b", "2").Callback("c", "3").
+		Row().URL("d", "https://example.com").SwitchInline("e", "")
+	if kb.Err() != nil {
+		t.Fatal(kb.Err())
+	}
+	rows := kb.Markup().InlineKeyboard
+	if len(rows) != 3 || len(rows[0]) != 2 || len(rows[1]) != 1 || len(rows[2]) != 2 || rows[2][0].URL == "" {
+		t.Fatalf("键盘布局错误: %+v", rows)
+	}
+
+	if NewInlineKeyboard().Callback("x", strings.Repeat("x", 65)).Err() == nil {
+		t.Fatal("超长的回调数据应返回错误")
+	}
+
+	data, err := json.Marshal(NewReplyKeyboard().Text("yes", "no").Row().Contact("phone").Resize().Markup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"keyboard":[[{"text":"yes"},{"text":"no"}],[{"text":"phone","request_contact":true}]],"resize_keyboard":true}` {
+		t.Fatalf("回复键盘错误: %s", data)
+	}
+}
+
+func TestBot_Menu(t *testing.T) {
+	message := func(text string) *telegram.Message {
+		return &telegram.Message{MessageID: 5, Chat: &telegram.Chat{ID: 100}, Text: text}
+	}
+	b := New(1, "token", nil)
+	menu := b.AddMenu("m", "主菜单", &MenuOptional{PageSize: 2})
+	fruits := menu.Submenu("fruits", "水果", "选择水果")
+	menu.Item("关于", "about")
+	fruits.Items(func(c *Context) ([]MenuItem, error) {
+		return []MenuItem{{Text: "苹果", CallbackData: "buy:apple"}, {Text: "香蕉", CallbackData: "buy:banana"}, {Text: "橙子", CallbackData: "buy:orange"}}, nil
+	})
+
+	root, err := menu.render(&Context{bot: b}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.InlineKeyboard) != 2 || root.InlineKeyboard[0][0].CallbackData != "m:fruits:0" {
+		t.Fatalf("主菜单错误: %+v", root.InlineKeyboard)
+	}
+
+	f := newFakeTelegram(t,
+		telegram.Update{UpdateID: 1, CallbackQuery: &telegram.CallbackQuery{ID: "q1", Message: message("主菜单"), Data: "m:fruits:0"}},
+		telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{ID: "q2", Message: message("选择水果"), Data: "m:fruits:1"}},
+	)
+	b.API.HTTPClient = b.API.HTTPClient.SetBaseURL(f.URL)
+	runBot(t, b)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Calls("editMessageText")) == 0 || len(f.Calls("editMessageReplyMarkup")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("菜单导航没有编辑消息")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if body := f.Calls("editMessageText")[0].Body; !strings.Contains(body, "选择水果") || !strings.Contains(body, "buy:banana") || !strings.Contains(body, `"callback_data":"m::0"`) {
+		t.Fatalf("进入子菜单错误: %s", body)
+	}
+	if body := f.Calls("editMessageReplyMarkup")[0].Body; !strings.Contains(body, "buy:orange") || strings.Contains(body, "buy:apple") || !strings.Contains(body, `"message_id":5`) {
+		t.Fatalf("翻页错误: %s", body)
+	}
+}
@@ -0,0 +1,211 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// fakeTelegram 模拟 telegram bot api
+// getUpdates 返回偏移量之后的预设更新，没有更新时阻塞直到请求被取消（timeout 为0时立即返回）
+type fakeTelegram struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	updates  []telegram.Update
+	results  map[string]string // 方法名对应的 result，未设置时返回 true
+	migrated map[string]int64  // 已升级为超级群组的群组（chat_id 对应新的 ID），请求时返回错误
+	calls    []fakeCall        // 除 getUpdates 外的调用记录
+	polls    []fakePoll        // getUpdates 的调用记录
+}
+
+// fakePoll getUpdates 调用记录
+type fakePoll struct {
+	Offset         int64
+	Limit          uint
+	AllowedUpdates []string
+}
+
+// fakeCall 调用记录
+type fakeCall struct {
+	Method string
+	Body   string
+}
+
+// newFakeTelegram 新建模拟 telegram bot api
+func newFakeTelegram(t *testing.T, updates ...telegram.Update) *fakeTelegram {
+	f := &fakeTelegram{
+		updates: updates,
+		results: map[string]string{
+			"getMe": `{"id":1,"is_bot":true,"first_name":"test","username":"test_bot"}`,
+		},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTelegram) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if method != "" { // 方法名不区分大小写，统一为首字母小写
+		method = strings.ToLower(method[:1]) + method[1:]
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if method == "getUpdates" {
+		var params struct {
+			Offset         int64    `json:"offset"`
+			Limit          uint     `json:"limit"`
+			Timeout        uint     `json:"timeout"`
+			AllowedUpdates []string `json:"allowed_updates"`
+		}
+		_ = json.Unmarshal(body, &params)
+
+		var result []telegram.Update
+		f.mu.Lock()
+		f.polls = append(f.polls, fakePoll{Offset: params.Offset, Limit: params.Limit, AllowedUpdates: params.AllowedUpdates})
+		for _, update := range f.updates {
+			if update.UpdateID >= params.Offset {
+				result = append(result, update)
+			}
+		}
+		f.mu.Unlock()
+
+		if len(result) == 0 && params.Timeout != 0 {
+			<-r.Context().Done()
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+		return
+	}
+
+	var params struct {
+		ChatID json.Number `json:"chat_id"`
+	}
+	_ = json.Unmarshal(body, &params)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{Method: method, Body: string(body)})
+	result, ok := f.results[method]
+	to, migrated := f.migrated[params.ChatID.String()]
+	f.mu.Unlock()
+	if migrated {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":          false,
+			"error_code":  http.StatusBadRequest,
+			"description": "Bad Request: group chat was upgraded to a supergroup chat",
+			"parameters":  map[string]int64{"migrate_to_chat_id": to},
+		})
+		return
+	}
+	if !ok {
+		result = "true"
+	}
+	_, _ = w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+}
+
+// Calls 获取指定方法的调用记录
+func (f *fakeTelegram) Calls(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []fakeCall
+	for _, call := range f.calls {
+		if call.Method == method {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+// Polls 获取 getUpdates 的调用记录
+func (f *fakeTelegram) Polls() []fakePoll {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakePoll{}, f.polls...)
+}
+
+// messageUpdate 新建消息更新，设置 m 的消息 ID、会话与发送者
+// 文本以 / 开头时添加命令实体，长度到第一个空格为止（包括 @botusername）
+func messageUpdate(id int64, chat *telegram.Chat, from *telegram.User, m telegram.Message) telegram.Update {
+	m.MessageID, m.Chat, m.From = id, chat, from
+	if strings.HasPrefix(m.Text, "/") {
+		length := len(m.Text)
+		if i := strings.IndexByte(m.Text, ' '); i != -1 {
+			length = i
+		}
+		m.Entities = []telegram.MessageEntity{{Type: telegram.MessageEntityAtBotCommand, Length: int64(length)}}
+	}
+	return telegram.Update{UpdateID: id, Message: &m}
+}
+
+// textUpdate 新建文本消息更新，见 messageUpdate
+func textUpdate(id int64, chat *telegram.Chat, from *telegram.User, text string) telegram.Update {
+	return messageUpdate(id, chat, from, telegram.Message{Text: text})
+}
+
+// newBot 新建连接到模拟 api 的 bot
+func (f *fakeTelegram) newBot(optional *BotOptional) *Bot {
+	b := New(1, "token", optional)
+	b.API.HTTPClient = b.API.HTTPClient.SetBaseURL(f.URL)
+	return b
+}
+
+// runBot 在后台运行 bot，测试结束时关闭
+func runBot(t *testing.T, b *Bot) {
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := b.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := <-runErr; err != ErrBotClosed {
+			t.Error(err)
+		}
+	})
+}
+
+// waitRunning 等待 bot 开始接收更新
+func waitRunning(t *testing.T, b *Bot) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		running := b.dispatcher != nil
+		b.mu.Unlock()
+		if running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("bot 未运行")
+}
+
+// collect 等待接收 n 条记录
+func collect(t *testing.T, ch <-chan string, n int) []string {
+	var result []string
+	timeout := time.After(5 * time.Second)
+	for len(result) < n {
+		select {
+		case s := <-ch:
+			result = append(result, s)
+		case <-timeout:
+			t.Fatalf("只收到 %d/%d 条记录: %v", len(result), n, result)
+		}
+	}
+	return result
+}
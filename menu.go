@@ -0,0 +1,217 @@
+package tgbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// MenuItem 菜单项，CallbackData 与 URL 二选一，通过 Menu.Submenu 添加的菜单项按下后打开子菜单
+type MenuItem struct {
+	Text         string // 按钮文本
+	CallbackData string // 按下后的回调数据，交给其他回调路由处理
+	URL          string // 按下后打开的链接
+
+	submenu *Menu
+}
+
+// MenuItemsFunc 动态生成菜单项（每次显示或翻页时调用）
+type MenuItemsFunc func(c *Context) ([]MenuItem, error)
+
+// MenuOptional 菜单可选参数
+type MenuOptional struct {
+	PageSize int    // 每页的菜单项数量，为0时不分页
+	Columns  int    // 每行的按钮数量，默认为1
+	PrevText string // 上一页按钮文本，默认为 « 上一页
+	NextText string // 下一页按钮文本，默认为 下一页 »
+	BackText string // 返回上级菜单按钮文本，默认为 « 返回
+}
+
+// menuRoot 菜单树共享的数据
+type menuRoot struct {
+	bot      *Bot
+	prefix   string
+	optional MenuOptional
+	menus    map[string]*Menu // 按路径索引的所有菜单
+}
+
+// Menu 分页菜单，菜单显示为消息文本与嵌入式键盘，翻页、进入子菜单与返回时编辑原消息
+type Menu struct {
+	root   *menuRoot
+	path   string // 菜单路径，根菜单为空，子菜单为 id/id
+	parent *Menu
+	title  string
+
+	items     []MenuItem
+	itemsFunc MenuItemsFunc
+}
+
+// menuNav 菜单导航的回调数据
+type menuNav struct {
+	Path string
+	Page int
+}
+
+// AddMenu 添加菜单，prefix 为菜单导航使用的回调数据前缀（见 AddCallback），title 为菜单的消息文本
+func (b *Bot) AddMenu(prefix string, title string, optional *MenuOptional) *Menu {
+	root := &menuRoot{bot: b, prefix: prefix, menus: map[string]*Menu{}}
+	if optional != nil {
+		root.optional = *optional
+	}
+	if root.optional.Columns <= 0 {
+		root.optional.Columns = 1
+	}
+	if root.optional.PrevText == "" {
+		root.optional.PrevText = "« 上一页"
+	}
+	if root.optional.NextText == "" {
+		root.optional.NextText = "下一页 »"
+	}
+	if root.optional.BackText == "" {
+		root.optional.BackText = "« 返回"
+	}
+
+	m := &Menu{root: root, title: title}
+	root.menus[""] = m
+	b.AddCallback(prefix, root.navigate)
+	return m
+}
+
+// Item 添加回调按钮菜单项
+func (m *Menu) Item(text string, callbackData string) *Menu {
+	m.items = append(m.items, MenuItem{Text: text, CallbackData: callbackData})
+	return m
+}
+
+// URL 添加链接菜单项
+func (m *Menu) URL(text string, url string) *Menu {
+	m.items = append(m.items, MenuItem{Text: text, URL: url})
+	return m
+}
+
+// Items 设置动态菜单项，显示在固定菜单项之后
+func (m *Menu) Items(fn MenuItemsFunc) *Menu {
+	m.itemsFunc = fn
+	return m
+}
+
+// Submenu 添加子菜单，返回子菜单。id 在同级菜单中唯一，text 为按钮文本，title 为子菜单的消息文本
+func (m *Menu) Submenu(id string, text string, title string) *Menu {
+	path := id
+	if m.path != "" {
+		path = m.path + "/" + id
+	}
+
+	sub := &Menu{root: m.root, path: path, parent: m, title: title}
+	m.root.menus[path] = sub
+	m.items = append(m.items, MenuItem{Text: text, submenu: sub})
+	return sub
+}
+
+// Show 在当前会话中发送菜单
+func (m *Menu) Show(c *Context) error {
+	markup, err := m.render(c, 0)
+	if err != nil {
+		return err
+	}
+	return c.Respond("sendMessage", map[string]interface{}{"chat_id": c.GetChatID(), "text": m.title, "reply_markup": markup}, nil)
+}
+
+// render 生成菜单指定页的键盘
+func (m *Menu) render(c *Context, page int) (*telegram.InlineKeyboardMarkup, error) {
+	items := m.items
+	if m.itemsFunc != nil {
+		dynamic, err := m.itemsFunc(c)
+		if err != nil {
+			return nil, err
+		}
+		items = append(append([]MenuItem{}, items...), dynamic...)
+	}
+
+	opt := m.root.optional
+	start, end := 0, len(items)
+	if opt.PageSize > 0 {
+		if page < 0 || page*opt.PageSize >= len(items) {
+			page = 0
+		}
+		start = page * opt.PageSize
+		if end > start+opt.PageSize {
+			end = start + opt.PageSize
+		}
+	}
+
+	var navErr error
+	nav := func(path string, page int) string {
+		data, err := m.root.bot.CallbackData(m.root.prefix, menuNav{Path: path, Page: page})
+		if err != nil && navErr == nil {
+			navErr = err
+		}
+		return data
+	}
+
+	kb := NewInlineKeyboard().Columns(opt.Columns)
+	for _, item := range items[start:end] {
+		switch {
+		case item.submenu != nil:
+			kb.Callback(item.Text, nav(item.submenu.path, 0))
+		case item.URL != "":
+			kb.URL(item.Text, item.URL)
+		default:
+			kb.Callback(item.Text, item.CallbackData)
+		}
+	}
+
+	kb.Columns(0).Row()
+	if start > 0 {
+		kb.Callback(opt.PrevText, nav(m.path, page-1))
+	}
+	if end < len(items) {
+		kb.Callback(opt.NextText, nav(m.path, page+1))
+	}
+	if m.parent != nil {
+		kb.Row().Callback(opt.BackText, nav(m.parent.path, 0))
+	}
+
+	if navErr != nil {
+		return nil, navErr
+	}
+	if err := kb.Err(); err != nil {
+		return nil, err
+	}
+	return kb.Markup(), nil
+}
+
+// navigate 处理菜单导航，编辑按钮所在的消息
+func (r *menuRoot) navigate(c *CallbackQueryContext) error {
+	var nav menuNav
+	if err := c.Payload(&nav); err != nil {
+		return err
+	}
+	m, ok := r.menus[nav.Path]
+	if !ok {
+		return fmt.Errorf("tgbot: menu %q not found", nav.Path)
+	}
+
+	markup, err := m.render(c.ctx, nav.Page)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{"reply_markup": markup}
+	if c.InlineMessageID != "" {
+		params["inline_message_id"] = c.InlineMessageID
+	} else if msg := c.CallbackQuery.Message; msg != nil {
+		params["chat_id"] = c.ctx.GetChatID()
+		params["message_id"] = msg.MessageID
+	} else {
+		return nil
+	}
+
+	// 同一菜单翻页时只需更新键盘
+	if msg := c.CallbackQuery.Message; msg != nil && strings.TrimSpace(msg.Text) == strings.TrimSpace(m.title) {
+		return c.Respond("editMessageReplyMarkup", params, nil)
+	}
+	params["text"] = m.title
+	return c.Respond("editMessageText", params, nil)
+}
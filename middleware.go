@@ -0,0 +1,97 @@
+package tgbot
+
+import "regexp"
+
+// Middleware 中间件，包裹处理器以便在其前后执行通用逻辑（日志、鉴权、限流等）
+// 不调用 next 即可拦截本次更新：返回 nil 时停止传递，返回 ErrContinue 时继续交给后续匹配的处理器
+type Middleware func(next MessageProcessorFunc) MessageProcessorFunc
+
+// Use 添加全局中间件，作用于命令、指定类型、默认以及内联查询等所有处理器
+// 先添加的中间件位于外层，先于后添加的中间件执行
+func (b *Bot) Use(middleware ...Middleware) {
+	b.middleware = append(b.middleware, middleware...)
+}
+
+// invoke 经过全局中间件调用处理器
+func (b *Bot) invoke(ctx *Context, fn MessageProcessorFunc) error {
+	return chain(b.middleware, fn)(ctx)
+}
+
+// chain 使用中间件包裹处理器
+func chain(middleware []Middleware, fn MessageProcessorFunc) MessageProcessorFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		fn = middleware[i](fn)
+	}
+	return fn
+}
+
+// Group 处理器分组，通过分组注册的处理器会在全局中间件之后再经过分组中间件
+// 分组中指定消息类型的处理器通过 HandleMessage(ContextTypeAt*, ...) 添加（处理器接收 *Context，而不是 SetMessageProcessorAt* 的类型化上下文）
+type Group struct {
+	bot         *Bot
+	parent      *Group
+	middleware  []Middleware
+	priority    int  // 分组的优先级
+	hasPriority bool // 是否设置了优先级，未设置时使用父分组的优先级
+}
+
+// Group 新建处理器分组
+func (b *Bot) Group(middleware ...Middleware) *Group {
+	return &Group{bot: b, middleware: middleware}
+}
+
+// Group 新建子分组，子分组会先经过父分组的中间件
+func (g *Group) Group(middleware ...Middleware) *Group {
+	return &Group{bot: g.bot, parent: g, middleware: middleware}
+}
+
+// Use 添加分组中间件
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// AddCommandProcessor 在分组中添加命令处理器
+func (g *Group) AddCommandProcessor(cmd string, execFunc MessageProcessorFunc) {
+	g.bot.addCommand(g.getPriority(), cmd, g.wrap(execFunc), nil)
+}
+
+// AddCommand 在分组中添加命令处理器
+func (g *Group) AddCommand(cmd string, execFunc MessageProcessorFunc, optional *CommandOptional) {
+	g.bot.addCommand(g.getPriority(), cmd, g.wrap(execFunc), optional)
+}
+
+// SetDefaultCommandProcessor 在分组中设置默认命令处理器
+func (g *Group) SetDefaultCommandProcessor(execFunc MessageProcessorFunc) {
+	g.bot.setDefaultCommandProcessor(g.getPriority(), g.wrap(execFunc))
+}
+
+// SetMessageProcessor 在分组中设置消息处理器
+func (g *Group) SetMessageProcessor(handleMessageFunc MessageProcessorFunc) {
+	g.bot.setMessageProcessor(g.getPriority(), g.wrap(handleMessageFunc))
+}
+
+// AddCallback 在分组中添加按前缀匹配的回调查询处理器
+func (g *Group) AddCallback(prefix string, fn CallbackQueryProcessorFunc) {
+	g.bot.addCallbackRoute(&callbackRoute{prefix: prefix, fn: g.wrap(callbackProcessor(fn))})
+}
+
+// AddCallbackPattern 在分组中添加按正则匹配的回调查询处理器
+func (g *Group) AddCallbackPattern(pattern *regexp.Regexp, fn CallbackQueryProcessorFunc) {
+	g.bot.addCallbackRoute(&callbackRoute{pattern: pattern, fn: g.wrap(callbackProcessor(fn))})
+}
+
+// HandleText 在分组中添加按正则匹配消息文本的处理器
+func (g *Group) HandleText(pattern *regexp.Regexp, fn MessageProcessorFunc, filters ...Filter) {
+	g.bot.handleText(g.getPriority(), pattern, g.wrap(fn), filters)
+}
+
+// wrap 使用分组（及其父分组）的中间件包裹处理器，中间件在调用时读取，注册后添加的中间件同样生效
+func (g *Group) wrap(fn MessageProcessorFunc) MessageProcessorFunc {
+	return func(c *Context) error {
+		var middleware []Middleware
+		for p := g; p != nil; p = p.parent {
+			middleware = append(append([]Middleware{}, p.middleware...), middleware...)
+		}
+		return chain(middleware, fn)(c)
+	}
+}
@@ -0,0 +1,58 @@
+package tgbot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_MiddlewareOrder(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtPrivate}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, nil, "/cmd"),
+		textUpdate(2, chat, nil, "hi"),
+		messageUpdate(3, chat, nil, telegram.Message{Photo: []telegram.PhotoSize{{FileID: "p"}}}),
+		telegram.Update{UpdateID: 4, InlineQuery: &telegram.InlineQuery{ID: "i4", From: &telegram.User{ID: 200}, Query: "q"}},
+	)
+	b := f.newBot(&BotOptional{Workers: 1})
+
+	got := make(chan string, 16)
+	trace := func(name string) Middleware {
+		return func(next MessageProcessorFunc) MessageProcessorFunc {
+			return func(c *Context) error {
+				got <- name
+				return next(c)
+			}
+		}
+	}
+	b.Use(trace("global"))
+	g := b.Group(trace("group"))
+	g.AddCommand("cmd", func(c *Context) error {
+		got <- "command"
+		return nil
+	}, nil)
+	g.HandleMessage(ContextTypeAtText, func(c *Context) error {
+		got <- "text"
+		return nil
+	})
+	g.SetMessageProcessor(func(c *Context) error {
+		got <- "default"
+		return nil
+	})
+	g.SetInlineQueryProcessor(func(c *InlineQueryContext) error {
+		got <- "inline"
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{
+		"global", "group", "command",
+		"global", "group", "text",
+		"global", "group", "default",
+		"global", "group", "inline",
+	}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("执行顺序 %v 与预期 %v 不一致", result, want)
+	}
+}
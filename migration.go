@@ -0,0 +1,71 @@
+package tgbot
+
+import (
+	"context"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// chatMigrated 请求因群组升级为超级群组而失败时调用，将升级交给 OnChatMigrated 设置的处理器（未设置时忽略）
+// 与原群组的 migrate_to_chat_id 服务消息合计，同一群组只调用一次
+func (b *Bot) chatMigrated(from, to int64) {
+	if from == 0 || !b.recordMigration(from, to) || b.chatMigratedFn == nil {
+		return
+	}
+
+	b.mu.Lock()
+	d := b.dispatcher
+	b.mu.Unlock()
+	if d == nil { // 未运行
+		return
+	}
+
+	// 生成的服务消息只用于确定串行键和创建上下文
+	update := &telegram.Update{Message: &telegram.Message{
+		Chat:            &telegram.Chat{ID: from, Type: telegram.ChatTypeAtGroup},
+		MigrateToChatID: to,
+	}}
+	// 请求可能在处理器中发起，异步提交以免阻塞所在的工作协程
+	go func() {
+		if err := d.submit(context.Background(), &job{update: update, migration: true}); err != nil && err != ErrBotClosed {
+			b.handleUpdateError(update, err)
+		}
+	}()
+}
+
+// handleChatMigrated 将通过失败的请求得知的群组升级交给 OnChatMigrated 设置的处理器，不经过其他处理器、中间件与幂等检查
+func (b *Bot) handleChatMigrated(update *telegram.Update) {
+	fn := b.chatMigratedFn
+	ctx := b.newContext(update)
+	b.runContext(ctx, func() {
+		err := fn(&ChatMigratedContext{
+			MessageContextBase: newMessageContextBase(ctx),
+
+			FromChatID: update.Message.Chat.ID,
+			ToChatID:   update.Message.MigrateToChatID,
+		})
+		if err != nil {
+			b.handleUpdateError(update, labelError("OnChatMigrated", err))
+		}
+	})
+}
+
+// recordMigration 记录群组升级，返回是否第一次记录
+func (b *Bot) recordMigration(from, to int64) bool {
+	b.migrationMu.Lock()
+	defer b.migrationMu.Unlock()
+	if b.migratedChats == nil {
+		b.migratedChats = map[int64]int64{}
+	}
+	_, ok := b.migratedChats[from]
+	b.migratedChats[from] = to
+	return !ok
+}
+
+// MigratedChatID 获取已知的群组升级后的超级群组 ID（通过服务消息或失败的请求得知），未升级时返回 false
+func (b *Bot) MigratedChatID(chatID int64) (int64, bool) {
+	b.migrationMu.Lock()
+	defer b.migrationMu.Unlock()
+	to, ok := b.migratedChats[chatID]
+	return to, ok
+}
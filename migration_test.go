@@ -0,0 +1,111 @@
+package tgbot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_ChatMigration(t *testing.T) {
+	group := &telegram.Chat{ID: -100, Type: telegram.ChatTypeAtGroup}
+	f := newFakeTelegram(t,
+		textUpdate(1, group, nil, "a"),
+		textUpdate(2, group, nil, "b"),
+		telegram.Update{UpdateID: 3, Message: &telegram.Message{MessageID: 3, Chat: group, MigrateToChatID: -1001}}, // 已通过请求得知，不再调用 OnChatMigrated
+	)
+	f.migrated = map[string]int64{"-100": -1001}
+	f.results["sendMessage"] = `{"message_id":10,"chat":{"id":-1001,"type":"supergroup"}}`
+	b := f.newBot(&BotOptional{Workers: 1, RetryMigratedChat: true})
+
+	got := make(chan string, 8)
+	b.OnChatMigrated(func(c *ChatMigratedContext) error {
+		got <- fmt.Sprint("migrated:", c.FromChatID, ":", c.ToChatID)
+		return nil
+	})
+	b.SetMessageProcessorAtText(func(c *TextMessageContext) error {
+		_, err := c.SendMessage(c.GetChatID(), c.Text, nil)
+		got <- fmt.Sprint("sent:", c.Text, ":", err)
+		return nil
+	})
+	runBot(t, b)
+
+	result := collect(t, got, 3)
+	want := map[string]bool{"sent:a:<nil>": true, "sent:b:<nil>": true, "migrated:-100:-1001": true}
+	for _, s := range result {
+		if !want[s] {
+			t.Fatalf("处理结果 %v 与预期不一致", result)
+		}
+		delete(want, s)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case s := <-got:
+		t.Fatalf("不应重复处理: %s", s)
+	default:
+	}
+	if to, ok := b.MigratedChatID(-100); !ok || to != -1001 {
+		t.Fatalf("MigratedChatID 结果 %d %v 与预期不一致", to, ok)
+	}
+
+	var chats []string
+	for _, call := range f.Calls("sendMessage") {
+		switch {
+		case strings.Contains(call.Body, `"chat_id":"-1001"`):
+			chats = append(chats, "-1001")
+		case strings.Contains(call.Body, `"chat_id":"-100"`):
+			chats = append(chats, "-100")
+		}
+	}
+	if wantChats := []string{"-100", "-1001", "-100", "-1001"}; !reflect.DeepEqual(chats, wantChats) {
+		t.Fatalf("sendMessage 请求的会话 %v 与预期 %v 不一致", chats, wantChats)
+	}
+
+	// 不重试时返回错误
+	nb := f.newBot(nil)
+	_, err := nb.API.SendMessage("-100", "c", nil)
+	if to, ok := telegram.MigratedChatID(err); !ok || to != -1001 {
+		t.Fatalf("错误 %v 中的新会话 ID 与预期不一致", err)
+	}
+}
+
+func TestBot_ChatMigrationWithoutHandler(t *testing.T) {
+	group := &telegram.Chat{ID: -100, Type: telegram.ChatTypeAtGroup}
+	f := newFakeTelegram(t, textUpdate(1, group, nil, "a"))
+	f.migrated = map[string]int64{"-100": -1001}
+	b := f.newBot(nil)
+
+	checked := make(chan string, 4)
+	b.SetIdempotencyCheck(func(update *telegram.Update) (bool, error) {
+		checked <- fmt.Sprint(update.UpdateID)
+		return false, nil
+	})
+	got := make(chan string, 4)
+	b.SetMessageProcessor(func(c *Context) error {
+		_, err := c.SendMessage(c.GetChatID(), "x", nil)
+		got <- fmt.Sprint(c.MessageType, ":", err != nil)
+		return nil
+	})
+	runBot(t, b)
+
+	if result := collect(t, got, 1); result[0] != ContextTypeAtText+":true" {
+		t.Fatalf("处理结果 %v 与预期不一致", result)
+	}
+	if to, ok := b.MigratedChatID(-100); !ok || to != -1001 {
+		t.Fatalf("MigratedChatID 结果 %d %v 与预期不一致", to, ok)
+	}
+
+	// 没有设置 OnChatMigrated 时不应生成服务消息交给其他处理器或幂等检查
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case s := <-got:
+		t.Fatalf("不应处理: %s", s)
+	default:
+	}
+	if result := collect(t, checked, 1); result[0] != "1" || len(checked) != 0 {
+		t.Fatalf("幂等检查的更新 %v 与预期不一致", result)
+	}
+}
@@ -0,0 +1,248 @@
+package tgbot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// OffsetStore 长轮询偏移量存储，偏移量为下一个待处理的更新 ID
+type OffsetStore interface {
+	Load() (int64, error)    // 读取偏移量，没有保存过时返回0
+	Save(offset int64) error // 保存偏移量
+}
+
+// MemoryOffsetStore 内存偏移量存储，重启后丢失
+type MemoryOffsetStore struct {
+	mu     sync.Mutex
+	offset int64
+}
+
+// NewMemoryOffsetStore 新建内存偏移量存储
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{}
+}
+
+// Load 实现 OffsetStore 接口
+func (s *MemoryOffsetStore) Load() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset, nil
+}
+
+// Save 实现 OffsetStore 接口
+func (s *MemoryOffsetStore) Save(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+	return nil
+}
+
+// FileOffsetStore 文件偏移量存储，每次保存都会写入临时文件后重命名替换
+type FileOffsetStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileOffsetStore 新建文件偏移量存储
+func NewFileOffsetStore(path string) *FileOffsetStore {
+	return &FileOffsetStore{path: path}
+}
+
+// Load 实现 OffsetStore 接口，文件不存在时返回0
+func (s *FileOffsetStore) Load() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("load offset file: %w", err)
+	}
+	return offset, nil
+}
+
+// Save 实现 OffsetStore 接口
+func (s *FileOffsetStore) Save(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.path, []byte(strconv.FormatInt(offset, 10)+"\n"))
+}
+
+// IdempotencyFunc 幂等检查函数，返回 true 时更新被视为已处理，不再交给处理器（偏移量照常前进）
+// 返回错误时记录错误并继续处理，宁可重复处理也不丢失更新
+type IdempotencyFunc func(update *telegram.Update) (bool, error)
+
+// SetOffsetStore 设置长轮询偏移量存储（webhook 模式不使用）
+// 运行时从存储中读取偏移量，更新的所有处理器结束后才确认该更新，偏移量只前进到最早的未处理完的更新，
+// 向 telegram 请求更新时也只确认到该偏移量。因此程序崩溃或 Shutdown 超时后，未处理完的更新会被重新投递（至少一次），
+// 已处理但尚未确认的更新也可能重复投递，可以通过 SetIdempotencyCheck 过滤
+func (b *Bot) SetOffsetStore(store OffsetStore) {
+	b.offsets = &offsetTracker{store: store, finished: map[int64]bool{}, changed: make(chan struct{})}
+}
+
+// SetIdempotencyCheck 设置幂等检查，每个更新交给处理器之前调用（包括 webhook 接收的更新）
+func (b *Bot) SetIdempotencyCheck(fn IdempotencyFunc) {
+	b.idempotencyCheck = fn
+}
+
+// offsetTracker 记录已提交给调度器的更新，按处理完成情况推进偏移量
+type offsetTracker struct {
+	store OffsetStore
+
+	mu        sync.Mutex
+	pending   []int64        // 已提交给调度器、尚未确认的更新 ID，按接收顺序
+	finished  map[int64]bool // pending 中已处理完的更新
+	committed int64          // 已确认的偏移量
+	changed   chan struct{}  // committed 变化时关闭并替换
+
+	saveMu sync.Mutex // 保存偏移量时持有，不阻塞持有 mu 的轮询与其他更新的确认
+	saved  int64      // 已保存的偏移量，由 saveMu 保护
+}
+
+// load 从存储中读取偏移量，返回值不小于 offset
+func (t *offsetTracker) load(offset int64) (int64, error) {
+	stored, err := t.store.Load()
+	if err != nil {
+		return 0, fmt.Errorf("load offset: %w", err)
+	}
+	if stored > offset {
+		offset = stored
+	}
+
+	t.saveMu.Lock()
+	t.saved = offset
+	t.saveMu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = nil
+	t.finished = map[int64]bool{}
+	t.committed = offset
+	return offset, nil
+}
+
+// offset 获取已确认的偏移量，以及偏移量变化时关闭的通道
+func (t *offsetTracker) offset() (int64, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed, t.changed
+}
+
+// receive 记录将要提交给调度器的更新，更新已在处理中（尚未确认）时返回 false
+func (t *offsetTracker) receive(updateID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range t.pending {
+		if id == updateID {
+			return false
+		}
+	}
+	t.pending = append(t.pending, updateID)
+	return true
+}
+
+// finish 记录处理完的更新，推进并保存偏移量。不是通过长轮询接收的更新会被忽略
+func (t *offsetTracker) finish(updateIDs ...int64) error {
+	if !t.advance(updateIDs) {
+		return nil
+	}
+	return t.save()
+}
+
+// advance 记录处理完的更新并推进偏移量，返回偏移量是否前进
+func (t *offsetTracker) advance(updateIDs []int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, id := range updateIDs {
+		for _, p := range t.pending {
+			if p == id {
+				t.finished[id] = true
+				break
+			}
+		}
+	}
+
+	committed := t.committed
+	for len(t.pending) != 0 && t.finished[t.pending[0]] {
+		delete(t.finished, t.pending[0])
+		committed = t.pending[0] + 1
+		t.pending = t.pending[1:]
+	}
+	if committed <= t.committed {
+		return false
+	}
+
+	t.committed = committed
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return true
+}
+
+// save 保存最新的已确认偏移量
+// 在 mu 之外写入存储；并发的保存依次执行，等待期间偏移量继续前进时由先获得锁的一方一并保存，已保存的不再重复写入
+func (t *offsetTracker) save() error {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	t.mu.Lock()
+	committed := t.committed
+	t.mu.Unlock()
+	if committed <= t.saved {
+		return nil
+	}
+
+	if err := t.store.Save(committed); err != nil {
+		return err
+	}
+	t.saved = committed
+	return nil
+}
+
+// finishOffset 更新处理完后推进偏移量
+func (b *Bot) finishOffset(j *job) {
+	if b.offsets == nil {
+		return
+	}
+
+	var ids []int64
+	if j.album != nil {
+		for _, update := range j.album {
+			ids = append(ids, update.UpdateID)
+		}
+	} else {
+		ids = append(ids, j.update.UpdateID)
+	}
+	if err := b.offsets.finish(ids...); err != nil {
+		b.handleUpdateError(j.update, fmt.Errorf("save offset: %w", err))
+	}
+}
+
+// alreadyHandled 通过幂等检查判断更新是否已处理
+func (b *Bot) alreadyHandled(update *telegram.Update) bool {
+	if b.idempotencyCheck == nil {
+		return false
+	}
+	handled, err := b.idempotencyCheck(update)
+	if err != nil {
+		b.handleUpdateError(update, fmt.Errorf("idempotency check: %w", err))
+		return false
+	}
+	return handled
+}
@@ -0,0 +1,145 @@
+package tgbot
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestFileOffsetStore(t *testing.T) {
+	s := NewFileOffsetStore(filepath.Join(t.TempDir(), "offset"))
+	if offset, err := s.Load(); err != nil || offset != 0 {
+		t.Fatalf("文件不存在时读取结果 %d %v 与预期不一致", offset, err)
+	}
+	if err := s.Save(42); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := NewFileOffsetStore(s.path).Load(); err != nil || offset != 42 {
+		t.Fatalf("读取结果 %d %v 与预期不一致", offset, err)
+	}
+}
+
+func TestBot_OffsetStore(t *testing.T) {
+	var updates []telegram.Update
+	for i := int64(1); i <= 5; i++ {
+		updates = append(updates, textUpdate(i, &telegram.Chat{ID: i}, nil, "m"))
+	}
+	f := newFakeTelegram(t, updates...)
+	b := f.newBot(&BotOptional{Workers: 3, KeyFunc: func(*telegram.Update) string { return "" }})
+
+	store := NewFileOffsetStore(filepath.Join(t.TempDir(), "offset"))
+	if err := store.Save(3); err != nil { // 1、2 已在上次运行中处理
+		t.Fatal(err)
+	}
+	b.SetOffsetStore(store)
+	b.SetIdempotencyCheck(func(update *telegram.Update) (bool, error) {
+		return update.UpdateID == 5, nil
+	})
+
+	got := make(chan int64, 8)
+	release := make(chan struct{})
+	b.SetMessageProcessor(func(c *Context) error {
+		if c.Update.UpdateID == 3 {
+			<-release
+		}
+		got <- c.Update.UpdateID
+		return nil
+	})
+	runBot(t, b)
+
+	if id := <-got; id != 4 {
+		t.Fatalf("处理的更新 %d 与预期不一致", id)
+	}
+	time.Sleep(50 * time.Millisecond) // 等待 5 被幂等检查跳过
+	if offset, err := store.Load(); err != nil || offset != 3 {
+		t.Fatalf("更新 3 未处理完时偏移量 %d %v 与预期不一致", offset, err)
+	}
+
+	close(release)
+	if id := <-got; id != 3 {
+		t.Fatalf("处理的更新 %d 与预期不一致", id)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offset, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("偏移量 %d 与预期不一致", offset)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case id := <-got:
+		t.Fatalf("不应处理更新 %d", id)
+	default:
+	}
+}
+
+// blockingOffsetStore 保存时阻塞直到 release 关闭的偏移量存储
+type blockingOffsetStore struct {
+	*MemoryOffsetStore
+	saving  chan int64
+	release chan struct{}
+}
+
+func (s *blockingOffsetStore) Save(offset int64) error {
+	s.saving <- offset
+	<-s.release
+	return s.MemoryOffsetStore.Save(offset)
+}
+
+func TestOffsetTracker_SaveOutsideLock(t *testing.T) {
+	store := &blockingOffsetStore{MemoryOffsetStore: NewMemoryOffsetStore(), saving: make(chan int64, 8), release: make(chan struct{})}
+	tracker := &offsetTracker{store: store, finished: map[int64]bool{}, changed: make(chan struct{})}
+	if _, err := tracker.load(1); err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		tracker.receive(id)
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- tracker.finish(1)
+	}()
+	if offset := <-store.saving; offset != 2 {
+		t.Fatalf("保存的偏移量 %d 与预期不一致", offset)
+	}
+
+	// 保存期间轮询与其他更新的确认不应被阻塞
+	done := make(chan int64)
+	go func() {
+		_ = tracker.finish(3)
+		tracker.receive(4)
+		offset, _ := tracker.offset()
+		done <- offset
+	}()
+	select {
+	case offset := <-done:
+		if offset != 2 {
+			t.Fatalf("已确认的偏移量 %d 与预期不一致", offset)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("保存偏移量时阻塞了偏移量的读取与确认")
+	}
+
+	go func() {
+		errs <- tracker.finish(2)
+	}()
+	close(store.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if offset, _ := store.Load(); offset != 4 {
+		t.Fatalf("保存的偏移量 %d 与预期不一致", offset)
+	}
+}
@@ -0,0 +1,365 @@
+package tgbot
+
+import (
+	"errors"
+
+	"github.com/elissa2333/tgbot/telegram"
+	"github.com/elissa2333/tgbot/utils"
+)
+
+var (
+	// ErrNoChat 更新不属于任何会话（如内联查询），无法发送消息
+	ErrNoChat = errors.New("tgbot: update has no chat")
+	// ErrNoMessage 更新不包含消息，无法回复、删除或转发
+	ErrNoMessage = errors.New("tgbot: update has no message")
+	// ErrNoReply 处理当前更新时还没有发送过消息，无法编辑
+	ErrNoReply = errors.New("tgbot: no previous reply")
+	// ErrNotCallbackQuery 更新不是回调查询
+	ErrNotCallbackQuery = errors.New("tgbot: update is not a callback query")
+)
+
+// currentMessage 获取当前更新的消息（回调查询为按钮所在的消息）
+func (c *Context) currentMessage() *telegram.Message {
+	if c.Message != nil {
+		return c.Message
+	}
+	if c.Update != nil && c.Update.CallbackQuery != nil {
+		return c.Update.CallbackQuery.Message
+	}
+	return nil
+}
+
+// sendParams 生成向当前会话发送消息的参数，消息位于论坛话题中时发送到同一话题
+func (c *Context) sendParams() (map[string]interface{}, error) {
+	chatID := c.GetChatID()
+	if chatID == "" {
+		return nil, ErrNoChat
+	}
+
+	params := map[string]interface{}{"chat_id": chatID}
+	if m := c.currentMessage(); m != nil && m.IsTopicMessage && m.MessageThreadID != 0 {
+		params["message_thread_id"] = m.MessageThreadID
+	}
+	return params, nil
+}
+
+// replyParams 生成回复当前消息的参数
+func (c *Context) replyParams() (map[string]interface{}, error) {
+	m := c.currentMessage()
+	if m == nil {
+		return nil, ErrNoMessage
+	}
+	params, err := c.sendParams()
+	if err != nil {
+		return nil, err
+	}
+	params["reply_to_message_id"] = m.MessageID
+	return params, nil
+}
+
+// send 调用发送消息的方法并记录发送的消息（见 EditLastReply），fileKey 不为空时以 multipart/form-data 上传文件
+func (c *Context) send(method string, params map[string]interface{}, fileKey string, file telegram.InputFile, optional interface{}) (*telegram.Message, error) {
+	result := &telegram.Message{}
+	var err error
+	if fileKey != "" {
+		params[fileKey] = file
+		err = c.API.Upload(method, params, optional, result)
+	} else {
+		err = c.API.Call(method, params, optional, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.lastReply = result
+	return result, nil
+}
+
+// Send 向当前会话发送文本消息，消息位于论坛话题中时发送到同一话题
+func (c *Context) Send(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	params, err := c.sendParams()
+	if err != nil {
+		return nil, err
+	}
+	params["text"] = text
+	return c.send("sendMessage", params, "", nil, optional)
+}
+
+// Reply 回复当前消息（回调查询为按钮所在的消息），optional 中的 ReplyToMessageID 不为0时回复指定消息
+func (c *Context) Reply(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	params, err := c.replyParams()
+	if err != nil {
+		return nil, err
+	}
+	params["text"] = text
+	return c.send("sendMessage", params, "", nil, optional)
+}
+
+// replyMedia 以文件回复当前消息
+func (c *Context) replyMedia(method string, fileKey string, file telegram.InputFile, optional interface{}) (*telegram.Message, error) {
+	params, err := c.replyParams()
+	if err != nil {
+		return nil, err
+	}
+	return c.send(method, params, fileKey, file, optional)
+}
+
+// ReplyPhoto 以照片回复当前消息
+func (c *Context) ReplyPhoto(photo telegram.InputFile, optional *telegram.SendPhotoOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendPhoto", "photo", photo, optional)
+}
+
+// ReplyAudio 以音频回复当前消息
+func (c *Context) ReplyAudio(audio telegram.InputFile, optional *telegram.SendAudioOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendAudio", "audio", audio, optional)
+}
+
+// ReplyDocument 以文件回复当前消息
+func (c *Context) ReplyDocument(document telegram.InputFile, optional *telegram.SendDocumentOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendDocument", "document", document, optional)
+}
+
+// ReplyVideo 以视频回复当前消息
+func (c *Context) ReplyVideo(video telegram.InputFile, optional *telegram.SendVideoOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendVideo", "video", video, optional)
+}
+
+// ReplyAnimation 以动画回复当前消息
+func (c *Context) ReplyAnimation(animation telegram.InputFile, optional *telegram.SendAnimationOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendAnimation", "animation", animation, optional)
+}
+
+// ReplyVoice 以语音回复当前消息
+func (c *Context) ReplyVoice(voice telegram.InputFile, optional *telegram.SendVoiceOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendVoice", "voice", voice, optional)
+}
+
+// ReplyVideoNote 以视频笔记回复当前消息
+func (c *Context) ReplyVideoNote(videoNote telegram.InputFile, optional *telegram.SendVideoNoteOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendVideoNote", "video_note", videoNote, optional)
+}
+
+// ReplySticker 以贴纸回复当前消息
+func (c *Context) ReplySticker(sticker telegram.InputFile, optional *telegram.SendStickerOptional) (*telegram.Message, error) {
+	return c.replyMedia("sendSticker", "sticker", sticker, optional)
+}
+
+// LastReply 获取处理当前更新时最后一次通过 Send、Reply 等方法发送的消息，没有时返回 nil
+func (c *Context) LastReply() *telegram.Message {
+	return c.lastReply
+}
+
+// EditLastReply 编辑最后一次通过 Send、Reply 等方法发送的消息的文本，
+// 还没有发送过消息时，回调查询编辑按钮所在的消息，其他更新返回 ErrNoReply
+// optional 中的 ChatID、MessageID 与 InlineMessageID 会被忽略
+func (c *Context) EditLastReply(text string, optional *telegram.EditMessageTextOptional) (*telegram.Message, error) {
+	target := c.lastReply
+	if target == nil && c.Update != nil && c.Update.CallbackQuery != nil {
+		target = c.Update.CallbackQuery.Message
+	}
+	if target == nil || target.Chat == nil {
+		return nil, ErrNoReply
+	}
+
+	params, err := telegram.BuildParams(nil, optional)
+	if err != nil {
+		return nil, err
+	}
+	delete(params, "inline_message_id")
+	params["chat_id"] = utils.ToString(target.Chat.ID)
+	params["message_id"] = target.MessageID
+	params["text"] = text
+
+	result := &telegram.Message{}
+	if err := c.API.Call("editMessageText", params, nil, result); err != nil {
+		return nil, err
+	}
+	if target == c.lastReply {
+		c.lastReply = result
+	}
+	return result, nil
+}
+
+// Delete 删除当前消息（回调查询为按钮所在的消息）
+func (c *Context) Delete() error {
+	m := c.currentMessage()
+	if m == nil || m.Chat == nil {
+		return ErrNoMessage
+	}
+	_, err := c.API.DeleteMessage(utils.ToString(m.Chat.ID), m.MessageID)
+	return err
+}
+
+// Forward 将当前消息转发到 chatID
+func (c *Context) Forward(chatID string, optional *telegram.ForwardMessageOptional) (*telegram.Message, error) {
+	m := c.currentMessage()
+	if m == nil || m.Chat == nil {
+		return nil, ErrNoMessage
+	}
+	return c.API.ForwardMessage(chatID, utils.ToString(m.Chat.ID), m.MessageID, optional)
+}
+
+// Copy 将当前消息复制到 chatID（不显示转发来源），返回新消息的 ID
+func (c *Context) Copy(chatID string, optional *telegram.CopyMessageOptional) (int64, error) {
+	m := c.currentMessage()
+	if m == nil || m.Chat == nil {
+		return 0, ErrNoMessage
+	}
+	return c.API.CopyMessage(chatID, utils.ToString(m.Chat.ID), m.MessageID, optional)
+}
+
+// ChatAction 在当前会话中显示 bot 的状态（telegram.ActionTypeAt*），如正在输入
+func (c *Context) ChatAction(action string) error {
+	params, err := c.sendParams()
+	if err != nil {
+		return err
+	}
+	params["action"] = action
+	return c.API.Call("sendChatAction", params, nil, nil)
+}
+
+// React 为当前消息设置表情回应，emojis 为空时移除 bot 的回应
+func (c *Context) React(emojis ...string) error {
+	m := c.currentMessage()
+	if m == nil || m.Chat == nil {
+		return ErrNoMessage
+	}
+
+	reaction := make([]map[string]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		reaction = append(reaction, map[string]string{"type": "emoji", "emoji": emoji})
+	}
+	return c.API.Call("setMessageReaction", map[string]interface{}{
+		"chat_id":    utils.ToString(m.Chat.ID),
+		"message_id": m.MessageID,
+		"reaction":   reaction,
+	}, nil, nil)
+}
+
+// AnswerCallback 应答当前回调查询，text 为空时不显示通知，应答后不再自动应答
+func (c *Context) AnswerCallback(text string, showAlert bool) error {
+	if c.Update == nil || c.Update.CallbackQuery == nil {
+		return ErrNotCallbackQuery
+	}
+
+	optional := &telegram.AnswerCallbackQueryOptional{Text: text}
+	if showAlert {
+		optional.ShowAlert = "true"
+	}
+	return c.Respond("answerCallbackQuery", map[string]interface{}{"callback_query_id": c.Update.CallbackQuery.ID}, optional)
+}
+
+// Send 见 Context.Send
+func (mcb *MessageContextBase) Send(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	return mcb.ctx.Send(text, optional)
+}
+
+// Reply 见 Context.Reply
+func (mcb *MessageContextBase) Reply(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	return mcb.ctx.Reply(text, optional)
+}
+
+// ReplyPhoto 见 Context.ReplyPhoto
+func (mcb *MessageContextBase) ReplyPhoto(photo telegram.InputFile, optional *telegram.SendPhotoOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyPhoto(photo, optional)
+}
+
+// ReplyAudio 见 Context.ReplyAudio
+func (mcb *MessageContextBase) ReplyAudio(audio telegram.InputFile, optional *telegram.SendAudioOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyAudio(audio, optional)
+}
+
+// ReplyDocument 见 Context.ReplyDocument
+func (mcb *MessageContextBase) ReplyDocument(document telegram.InputFile, optional *telegram.SendDocumentOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyDocument(document, optional)
+}
+
+// ReplyVideo 见 Context.ReplyVideo
+func (mcb *MessageContextBase) ReplyVideo(video telegram.InputFile, optional *telegram.SendVideoOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyVideo(video, optional)
+}
+
+// ReplyAnimation 见 Context.ReplyAnimation
+func (mcb *MessageContextBase) ReplyAnimation(animation telegram.InputFile, optional *telegram.SendAnimationOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyAnimation(animation, optional)
+}
+
+// ReplyVoice 见 Context.ReplyVoice
+func (mcb *MessageContextBase) ReplyVoice(voice telegram.InputFile, optional *telegram.SendVoiceOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyVoice(voice, optional)
+}
+
+// ReplyVideoNote 见 Context.ReplyVideoNote
+func (mcb *MessageContextBase) ReplyVideoNote(videoNote telegram.InputFile, optional *telegram.SendVideoNoteOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplyVideoNote(videoNote, optional)
+}
+
+// ReplySticker 见 Context.ReplySticker
+func (mcb *MessageContextBase) ReplySticker(sticker telegram.InputFile, optional *telegram.SendStickerOptional) (*telegram.Message, error) {
+	return mcb.ctx.ReplySticker(sticker, optional)
+}
+
+// LastReply 见 Context.LastReply
+func (mcb *MessageContextBase) LastReply() *telegram.Message {
+	return mcb.ctx.LastReply()
+}
+
+// EditLastReply 见 Context.EditLastReply
+func (mcb *MessageContextBase) EditLastReply(text string, optional *telegram.EditMessageTextOptional) (*telegram.Message, error) {
+	return mcb.ctx.EditLastReply(text, optional)
+}
+
+// Delete 见 Context.Delete
+func (mcb *MessageContextBase) Delete() error {
+	return mcb.ctx.Delete()
+}
+
+// Forward 见 Context.Forward
+func (mcb *MessageContextBase) Forward(chatID string, optional *telegram.ForwardMessageOptional) (*telegram.Message, error) {
+	return mcb.ctx.Forward(chatID, optional)
+}
+
+// Copy 见 Context.Copy
+func (mcb *MessageContextBase) Copy(chatID string, optional *telegram.CopyMessageOptional) (int64, error) {
+	return mcb.ctx.Copy(chatID, optional)
+}
+
+// ChatAction 见 Context.ChatAction
+func (mcb *MessageContextBase) ChatAction(action string) error {
+	return mcb.ctx.ChatAction(action)
+}
+
+// React 见 Context.React
+func (mcb *MessageContextBase) React(emojis ...string) error {
+	return mcb.ctx.React(emojis...)
+}
+
+// Send 见 Context.Send
+func (c *CallbackQueryContext) Send(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	return c.ctx.Send(text, optional)
+}
+
+// Reply 见 Context.Reply
+func (c *CallbackQueryContext) Reply(text string, optional *telegram.SendMessageOptional) (*telegram.Message, error) {
+	return c.ctx.Reply(text, optional)
+}
+
+// EditLastReply 见 Context.EditLastReply
+func (c *CallbackQueryContext) EditLastReply(text string, optional *telegram.EditMessageTextOptional) (*telegram.Message, error) {
+	return c.ctx.EditLastReply(text, optional)
+}
+
+// Delete 见 Context.Delete
+func (c *CallbackQueryContext) Delete() error {
+	return c.ctx.Delete()
+}
+
+// ChatAction 见 Context.ChatAction
+func (c *CallbackQueryContext) ChatAction(action string) error {
+	return c.ctx.ChatAction(action)
+}
+
+// AnswerCallback 见 Context.AnswerCallback
+func (c *CallbackQueryContext) AnswerCallback(text string, showAlert bool) error {
+	return c.ctx.AnswerCallback(text, showAlert)
+}
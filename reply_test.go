@@ -0,0 +1,104 @@
+package tgbot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestContext_Reply(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtSuperGroup}
+	f := newFakeTelegram(t,
+		telegram.Update{UpdateID: 1, Message: &telegram.Message{MessageID: 5, Chat: chat, Text: "hi", MessageThreadID: 7, IsTopicMessage: true}},
+		telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{ID: "cb", Data: "x", Message: &telegram.Message{MessageID: 6, Chat: chat}}},
+	)
+	f.results["sendMessage"] = `{"message_id":10,"chat":{"id":100,"type":"supergroup"}}`
+	f.results["sendPhoto"] = `{"message_id":11,"chat":{"id":100,"type":"supergroup"}}`
+	f.results["editMessageText"] = `{"message_id":10,"chat":{"id":100,"type":"supergroup"}}`
+	b := f.newBot(&BotOptional{Workers: 1})
+
+	done := make(chan string, 4)
+	b.SetMessageProcessorAtText(func(c *TextMessageContext) error {
+		if _, err := c.EditLastReply("no reply", nil); err != ErrNoReply {
+			t.Errorf("没有发送过消息时 EditLastReply 返回 %v", err)
+		}
+		if err := c.ChatAction(telegram.ActionTypeAtTyping); err != nil {
+			return err
+		}
+		if _, err := c.Reply("pong", nil); err != nil {
+			return err
+		}
+		if _, err := c.EditLastReply("pong!", nil); err != nil {
+			return err
+		}
+		if _, err := c.ReplyPhoto(strings.NewReader("image"), &telegram.SendPhotoOptional{Caption: "photo"}); err != nil {
+			return err
+		}
+		if c.LastReply().MessageID != 11 {
+			t.Errorf("LastReply %d 与预期不一致", c.LastReply().MessageID)
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		done <- "message"
+		return nil
+	})
+	b.SetCallbackQueryProcessor(func(c *CallbackQueryContext) error {
+		if _, err := c.EditLastReply("edited", nil); err != nil {
+			return err
+		}
+		if err := c.AnswerCallback("ok", false); err != nil {
+			return err
+		}
+		done <- "callback"
+		return nil
+	})
+	runBot(t, b)
+	collect(t, done, 2)
+
+	params := func(method string, i int) map[string]interface{} {
+		calls := f.Calls(method)
+		if len(calls) <= i {
+			t.Fatalf("%s 调用次数 %d 与预期不一致", method, len(calls))
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(calls[i].Body), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	expect := func(m map[string]interface{}, key string, want interface{}) {
+		if got := m[key]; got != want {
+			t.Fatalf("参数 %s 为 %v，预期为 %v（%v）", key, got, want, m)
+		}
+	}
+
+	action := params("sendChatAction", 0)
+	expect(action, "chat_id", "100")
+	expect(action, "message_thread_id", float64(7))
+
+	reply := params("sendMessage", 0)
+	expect(reply, "text", "pong")
+	expect(reply, "reply_to_message_id", float64(5))
+	expect(reply, "message_thread_id", float64(7))
+
+	edit := params("editMessageText", 0)
+	expect(edit, "message_id", float64(10))
+	expect(edit, "text", "pong!")
+
+	photo := f.Calls("sendPhoto")
+	if len(photo) != 1 || !strings.Contains(photo[0].Body, "image") || !strings.Contains(photo[0].Body, `name="reply_to_message_id"`) {
+		t.Fatalf("sendPhoto 调用 %v 与预期不一致", photo)
+	}
+
+	expect(params("deleteMessage", 0), "message_id", float64(5))
+
+	callbackEdit := params("editMessageText", 1)
+	expect(callbackEdit, "message_id", float64(6))
+	expect(callbackEdit, "text", "edited")
+	if answers := f.Calls("answerCallbackQuery"); len(answers) != 1 {
+		t.Fatalf("answerCallbackQuery 调用次数 %d 与预期不一致", len(answers))
+	}
+}
@@ -0,0 +1,185 @@
+package tgbot
+
+import (
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// UserJoinedContext 新成员加入上下文
+type UserJoinedContext struct {
+	MessageContextBase
+
+	Users []telegram.User // 新成员（bot 自身可能是其中之一）
+}
+
+// UserJoinedProcessorFunc 新成员加入处理函数
+type UserJoinedProcessorFunc func(c *UserJoinedContext) error
+
+// OnUserJoined 新成员加入处理器
+func (b *Bot) OnUserJoined(fn UserJoinedProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtNewChatMembers, fn)
+}
+
+// UserLeftContext 成员离开上下文
+type UserLeftContext struct {
+	MessageContextBase
+
+	User *telegram.User // 离开（或被移出）的成员（可能是 bot 自身）
+}
+
+// UserLeftProcessorFunc 成员离开处理函数
+type UserLeftProcessorFunc func(c *UserLeftContext) error
+
+// OnUserLeft 成员离开处理器
+func (b *Bot) OnUserLeft(fn UserLeftProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtLeftChatMember, fn)
+}
+
+// ChatTitleChangedContext 会话标题修改上下文
+type ChatTitleChangedContext struct {
+	MessageContextBase
+
+	Title string // 新标题
+}
+
+// ChatTitleChangedProcessorFunc 会话标题修改处理函数
+type ChatTitleChangedProcessorFunc func(c *ChatTitleChangedContext) error
+
+// OnChatTitleChanged 会话标题修改处理器
+func (b *Bot) OnChatTitleChanged(fn ChatTitleChangedProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtNewChatTitle, fn)
+}
+
+// ChatPhotoChangedContext 会话照片修改上下文
+type ChatPhotoChangedContext struct {
+	MessageContextBase
+
+	Photo   []telegram.PhotoSize // 新照片，删除时为空
+	Deleted bool                 // 照片是否被删除
+}
+
+// ChatPhotoChangedProcessorFunc 会话照片修改处理函数
+type ChatPhotoChangedProcessorFunc func(c *ChatPhotoChangedContext) error
+
+// OnChatPhotoChanged 会话照片修改（包括删除）处理器
+func (b *Bot) OnChatPhotoChanged(fn ChatPhotoChangedProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtNewChatPhoto, fn)
+	b.setMessageProcessorAt(ContextTypeAtDeleteChatPhoto, fn)
+}
+
+// GroupCreatedContext 群组创建上下文
+type GroupCreatedContext struct {
+	MessageContextBase
+}
+
+// GroupCreatedProcessorFunc 群组创建处理函数
+type GroupCreatedProcessorFunc func(c *GroupCreatedContext) error
+
+// OnGroupCreated 群组创建处理器
+func (b *Bot) OnGroupCreated(fn GroupCreatedProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtGroupChatCreated, fn)
+}
+
+// MessagePinnedContext 消息置顶上下文
+type MessagePinnedContext struct {
+	MessageContextBase
+
+	PinnedMessage *telegram.Message // 被置顶的消息（不包含 ReplyToMessage）
+}
+
+// MessagePinnedProcessorFunc 消息置顶处理函数
+type MessagePinnedProcessorFunc func(c *MessagePinnedContext) error
+
+// OnMessagePinned 消息置顶处理器
+func (b *Bot) OnMessagePinned(fn MessagePinnedProcessorFunc) {
+	b.setMessageProcessorAt(ContextTypeAtPinnedMessage, fn)
+}
+
+// ChatMigratedContext 群组升级为超级群组上下文
+type ChatMigratedContext struct {
+	MessageContextBase
+
+	FromChatID int64 // 原群组 ID
+	ToChatID   int64 // 新的超级群组 ID
+}
+
+// ChatMigratedProcessorFunc 群组升级处理函数
+type ChatMigratedProcessorFunc func(c *ChatMigratedContext) error
+
+// OnChatMigrated 群组升级为超级群组处理器，在原群组收到 migrate_to_chat_id 服务消息时调用，
+// 请求因群组升级而失败时也会调用（不经过中间件），同一群组只调用一次
+// （新的超级群组中的 migrate_from_chat_id 服务消息不会重复调用，可以通过 HandleMessage 处理 ContextTypeAtMigrateFromChat）
+func (b *Bot) OnChatMigrated(fn ChatMigratedProcessorFunc) {
+	b.chatMigratedFn = fn
+	b.setMessageProcessorAt(ContextTypeAtMigrateToChat, fn)
+}
+
+// serviceMessageProcessor 将服务消息处理器转换为通用处理器，fn 不是服务消息处理器时返回 nil
+func serviceMessageProcessor(fn interface{}) MessageProcessorFunc {
+	switch fn := fn.(type) {
+	case UserJoinedProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnUserJoined", fn(&UserJoinedContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				Users: c.Message.NewChatMembers,
+			}))
+		}
+	case UserLeftProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnUserLeft", fn(&UserLeftContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				User: c.Message.LeftChatMember,
+			}))
+		}
+	case ChatTitleChangedProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnChatTitleChanged", fn(&ChatTitleChangedContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				Title: c.Message.EwChatTitle,
+			}))
+		}
+	case ChatPhotoChangedProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnChatPhotoChanged", fn(&ChatPhotoChangedContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				Photo:   c.Message.NewChatPhoto,
+				Deleted: c.Message.DeleteChatPhoto,
+			}))
+		}
+	case GroupCreatedProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnGroupCreated", fn(&GroupCreatedContext{
+				MessageContextBase: newMessageContextBase(c),
+			}))
+		}
+	case MessagePinnedProcessorFunc:
+		return func(c *Context) error {
+			return labelError("OnMessagePinned", fn(&MessagePinnedContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				PinnedMessage: c.Message.PinnedMessage,
+			}))
+		}
+	case ChatMigratedProcessorFunc:
+		return func(c *Context) error {
+			if c.migrationKnown { // 已通过失败的请求调用过
+				return nil
+			}
+
+			var from int64
+			if c.Message.Chat != nil {
+				from = c.Message.Chat.ID
+			}
+			return labelError("OnChatMigrated", fn(&ChatMigratedContext{
+				MessageContextBase: newMessageContextBase(c),
+
+				FromChatID: from,
+				ToChatID:   c.Message.MigrateToChatID,
+			}))
+		}
+	}
+	return nil
+}
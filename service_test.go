@@ -0,0 +1,67 @@
+package tgbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestBot_ServiceMessages(t *testing.T) {
+	var titled telegram.Message
+	if err := json.Unmarshal([]byte(`{"message_id":3,"new_chat_title":"新标题"}`), &titled); err != nil {
+		t.Fatal(err)
+	}
+	if titled.EwChatTitle != "新标题" {
+		t.Fatalf("new_chat_title 解码结果 %q 与预期不一致", titled.EwChatTitle)
+	}
+
+	group := &telegram.Chat{ID: -100, Type: telegram.ChatTypeAtGroup}
+	f := newFakeTelegram(t,
+		messageUpdate(1, group, nil, telegram.Message{NewChatMembers: []telegram.User{{ID: 1}, {ID: 2}}}),
+		messageUpdate(2, group, nil, telegram.Message{LeftChatMember: &telegram.User{ID: 1}}),
+		messageUpdate(3, group, nil, titled),
+		messageUpdate(4, group, nil, telegram.Message{DeleteChatPhoto: true}),
+		messageUpdate(5, group, nil, telegram.Message{PinnedMessage: &telegram.Message{MessageID: 1, Text: "公告"}}),
+		messageUpdate(6, group, nil, telegram.Message{MigrateToChatID: -1001}),
+		messageUpdate(7, group, nil, telegram.Message{GroupChatCreated: true}),
+	)
+	b := f.newBot(&BotOptional{Workers: 1})
+	got := make(chan string, 16)
+	b.OnUserJoined(func(c *UserJoinedContext) error {
+		got <- fmt.Sprint("joined:", len(c.Users))
+		return nil
+	})
+	b.OnUserLeft(func(c *UserLeftContext) error {
+		got <- fmt.Sprint("left:", c.User.ID)
+		return nil
+	})
+	b.OnChatTitleChanged(func(c *ChatTitleChangedContext) error {
+		got <- "title:" + c.Title
+		return nil
+	})
+	b.OnChatPhotoChanged(func(c *ChatPhotoChangedContext) error {
+		got <- fmt.Sprint("photo:", c.Deleted)
+		return nil
+	})
+	b.OnMessagePinned(func(c *MessagePinnedContext) error {
+		got <- "pinned:" + c.PinnedMessage.Text
+		return nil
+	})
+	b.OnChatMigrated(func(c *ChatMigratedContext) error {
+		got <- fmt.Sprint("migrated:", c.FromChatID, ":", c.ToChatID)
+		return nil
+	})
+	b.SetMessageProcessor(func(c *Context) error {
+		got <- "default:" + c.MessageType
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"joined:2", "left:1", "title:新标题", "photo:true", "pinned:公告", "migrated:-100:-1001", "default:group_chat_created"}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("服务消息处理结果 %v 与预期 %v 不一致", result, want)
+	}
+}
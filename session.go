@@ -0,0 +1,474 @@
+package tgbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elissa2333/tgbot/utils"
+)
+
+// SessionStore 会话数据存储，值为 JSON 编码后的数据
+type SessionStore interface {
+	Get(key string) ([]byte, error)                        // 不存在或已过期时返回 nil, nil
+	Set(key string, value []byte, ttl time.Duration) error // ttl 为0时不过期
+	Delete(key string) error
+}
+
+// sessionEntry 存储中的会话数据
+type sessionEntry struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt time.Time       `json:"expires_at,omitempty"` // 为零值时不过期
+}
+
+// expired 是否已过期
+func (e sessionEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// newSessionEntry 新建会话数据
+func newSessionEntry(value []byte, ttl time.Duration) sessionEntry {
+	e := sessionEntry{Value: append(json.RawMessage{}, value...)}
+	if ttl > 0 {
+		e.ExpiresAt = time.Now().Add(ttl)
+	}
+	return e
+}
+
+// MemorySessionStore 内存会话数据存储（重启后丢失）
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	entries map[string]sessionEntry
+}
+
+// NewMemorySessionStore 新建内存会话数据存储
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{entries: map[string]sessionEntry{}}
+}
+
+// Get 实现 SessionStore 接口
+func (s *MemorySessionStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if e.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return append([]byte{}, e.Value...), nil
+}
+
+// Set 实现 SessionStore 接口
+func (s *MemorySessionStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = newSessionEntry(value, ttl)
+	return nil
+}
+
+// Delete 实现 SessionStore 接口
+func (s *MemorySessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// FileSessionStore 单文件会话数据存储
+// 所有数据保存在一个 JSON 文件中，每次修改都会写入临时文件后重命名替换，保证文件始终完整
+type FileSessionStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]sessionEntry
+}
+
+// NewFileSessionStore 新建单文件会话数据存储，文件存在时加载其中的数据
+func NewFileSessionStore(path string) (*FileSessionStore, error) {
+	s := &FileSessionStore{path: path, entries: map[string]sessionEntry{}}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := json.Unmarshal(data, &s.entries); err != nil {
+			return nil, fmt.Errorf("load session file: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Get 实现 SessionStore 接口
+func (s *FileSessionStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, nil
+	}
+	return append([]byte{}, e.Value...), nil
+}
+
+// Set 实现 SessionStore 接口
+func (s *FileSessionStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.entries[key]
+	s.entries[key] = newSessionEntry(value, ttl)
+	if err := s.flush(); err != nil {
+		if ok {
+			s.entries[key] = old
+		} else {
+			delete(s.entries, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete 实现 SessionStore 接口
+func (s *FileSessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	if err := s.flush(); err != nil {
+		s.entries[key] = old
+		return err
+	}
+	return nil
+}
+
+// flush 清理过期数据后写入文件，调用者需持有锁
+func (s *FileSessionStore) flush() error {
+	now := time.Now()
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic 写入同目录下的临时文件并同步到磁盘后重命名为目标文件
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// SessionKeyFunc 计算会话数据的键，返回空字符串时该更新没有会话数据
+type SessionKeyFunc func(c *Context) string
+
+// SessionKeyAtUser 按用户区分会话数据（同一用户在不同会话中共享）
+func SessionKeyAtUser(c *Context) string {
+	if user := c.GetFrom(); user != nil {
+		return "user:" + utils.ToString(user.ID)
+	}
+	return ""
+}
+
+// SessionKeyAtChat 按会话区分会话数据（群组中的所有成员共享）
+func SessionKeyAtChat(c *Context) string {
+	if chat := c.GetChat(); chat != nil {
+		return "chat:" + utils.ToString(chat.ID)
+	}
+	return ""
+}
+
+// SessionKeyAtChatUser 按会话中的用户区分会话数据（默认）
+func SessionKeyAtChatUser(c *Context) string {
+	chat, user := c.GetChat(), c.GetFrom()
+	if chat == nil || user == nil {
+		return SessionKeyAtUser(c)
+	}
+	return "chat:" + utils.ToString(chat.ID) + ":user:" + utils.ToString(user.ID)
+}
+
+// SessionOptional 会话数据可选参数
+type SessionOptional struct {
+	TTL     time.Duration  // 会话数据的有效期，每次保存时重新计算，为0时不过期
+	KeyFunc SessionKeyFunc // 会话数据的键，默认为 SessionKeyAtChatUser
+
+	// RefreshInterval 数据没有变化时，距上次保存超过该时间才重新保存以刷新有效期（避免每个更新都写入存储），
+	// 默认（为0时）为 TTL 的十分之一。有效期因此最多提前 RefreshInterval 结束
+	RefreshInterval time.Duration
+}
+
+// SetSessionStore 设置会话数据存储，设置后处理器可以通过 Context.Session 读写会话数据
+func (b *Bot) SetSessionStore(store SessionStore, optional *SessionOptional) {
+	b.sessionStore = store
+	b.sessionOptional = SessionOptional{KeyFunc: SessionKeyAtChatUser}
+	if optional != nil {
+		b.sessionOptional.TTL = optional.TTL
+		b.sessionOptional.RefreshInterval = optional.RefreshInterval
+		if optional.KeyFunc != nil {
+			b.sessionOptional.KeyFunc = optional.KeyFunc
+		}
+	}
+	if b.sessionOptional.RefreshInterval <= 0 {
+		b.sessionOptional.RefreshInterval = b.sessionOptional.TTL / 10
+	}
+}
+
+// ErrNoSession 没有设置会话数据存储或当前更新没有会话数据的键
+var ErrNoSession = errors.New("tgbot: session not available")
+
+// sessionRuntime 处理单个更新时的会话数据
+type sessionRuntime struct {
+	key     string
+	ptr     interface{} // 处理器提供的结构体指针，处理结束后编码保存
+	loaded  []byte      // 加载时的数据，用于判断是否发生变化
+	deleted bool
+	unlock  func()
+}
+
+// Session 将会话数据解码到 ptr（结构体或 map 的指针），更新处理结束后 ptr 的内容会被保存
+// 同一个更新中只需调用一次，再次调用时解码到新的 ptr 并以新的 ptr 为准。
+// 同一键的会话数据在处理期间加锁，并发的更新会依次执行
+func (c *Context) Session(ptr interface{}) error {
+	r, err := c.loadSession()
+	if err != nil {
+		return err
+	}
+
+	if len(r.loaded) != 0 {
+		if err := json.Unmarshal(r.loaded, ptr); err != nil {
+			return fmt.Errorf("decode session: %w", err)
+		}
+	}
+	r.ptr = ptr
+	r.deleted = false
+	return nil
+}
+
+// DeleteSession 删除会话数据（更新处理结束后生效）
+func (c *Context) DeleteSession() error {
+	r, err := c.loadSession()
+	if err != nil {
+		return err
+	}
+	r.ptr, r.deleted = nil, true
+	return nil
+}
+
+// loadSession 加锁并加载会话数据
+func (c *Context) loadSession() (*sessionRuntime, error) {
+	if c.session != nil {
+		return c.session, nil
+	}
+
+	b := c.bot
+	if b == nil || b.sessionStore == nil {
+		return nil, ErrNoSession
+	}
+	key := b.sessionOptional.KeyFunc(c)
+	if key == "" {
+		return nil, ErrNoSession
+	}
+
+	// 先锁定会话状态，保证两种锁总是按相同顺序获取，避免死锁
+	if conversationKey(c) != "" {
+		if _, err := b.lockConversation(c); err != nil {
+			return nil, err
+		}
+	}
+	unlock := b.sessionLocks.lock(key)
+	data, err := b.sessionStore.Get(key)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	c.session = &sessionRuntime{key: key, loaded: data, unlock: unlock}
+	return c.session, nil
+}
+
+// saveSession 保存会话数据并释放锁
+func (b *Bot) saveSession(c *Context) error {
+	r := c.session
+	if r == nil {
+		return nil
+	}
+	defer r.unlock()
+	c.session = nil
+
+	if r.deleted {
+		b.sessionSaved.forget(r.key)
+		return b.sessionStore.Delete(r.key)
+	}
+	if r.ptr == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r.ptr)
+	if err != nil {
+		return err
+	}
+
+	ttl, now := b.sessionOptional.TTL, time.Now()
+	if bytes.Equal(data, r.loaded) { // 没有变化，只在需要刷新有效期时保存
+		if ttl == 0 || !b.sessionSaved.due(r.key, now, b.sessionOptional.RefreshInterval) {
+			return nil
+		}
+	}
+	if err := b.sessionStore.Set(r.key, data, ttl); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		b.sessionSaved.saved(r.key, now, ttl)
+	}
+	return nil
+}
+
+// releaseSession 释放会话数据的锁，不保存修改
+func (b *Bot) releaseSession(c *Context) {
+	r := c.session
+	if r == nil {
+		return
+	}
+	c.session = nil
+	r.unlock()
+}
+
+// Session 见 Context.Session
+func (mcb *MessageContextBase) Session(ptr interface{}) error {
+	return mcb.ctx.Session(ptr)
+}
+
+// DeleteSession 见 Context.DeleteSession
+func (mcb *MessageContextBase) DeleteSession() error {
+	return mcb.ctx.DeleteSession()
+}
+
+// sessionSaveTimes 会话数据最后一次保存的时间，用于判断没有变化的数据是否需要刷新有效期
+type sessionSaveTimes struct {
+	mu     sync.Mutex
+	times  map[string]time.Time
+	pruned time.Time // 上次清理已过期记录的时间
+}
+
+// due 是否需要重新保存，没有保存记录（如重启后）时需要
+func (s *sessionSaveTimes) due(key string, now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.times[key]
+	return !ok || now.Sub(t) >= interval
+}
+
+// saved 记录保存时间，并清理超过有效期（数据已过期）的记录
+func (s *sessionSaveTimes) saved(key string, now time.Time, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.times == nil {
+		s.times = map[string]time.Time{}
+	}
+	s.times[key] = now
+	if now.Sub(s.pruned) < ttl {
+		return
+	}
+	s.pruned = now
+	for k, t := range s.times {
+		if now.Sub(t) >= ttl {
+			delete(s.times, k)
+		}
+	}
+}
+
+// forget 删除保存记录
+func (s *sessionSaveTimes) forget(key string) {
+	s.mu.Lock()
+	delete(s.times, key)
+	s.mu.Unlock()
+}
+
+// keyedMutex 按键加锁
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock 单个键的锁
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock 锁定键，返回解锁函数
+func (m *keyedMutex) lock(key string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedLock{}
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		m.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,201 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	s, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("a", []byte(`{"n":1}`), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("b", []byte(`{"n":2}`), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	s, err = NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("a"); err != nil || string(v) != `{"n":1}` {
+		t.Fatalf("重新加载后数据错误: %s %v", v, err)
+	}
+	if v, err := s.Get("b"); err != nil || v != nil {
+		t.Fatalf("过期数据不应返回: %s %v", v, err)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewFileSessionStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("a"); v != nil {
+		t.Fatalf("删除后数据仍存在: %s", v)
+	}
+}
+
+func TestBot_Session(t *testing.T) {
+	const n = 50
+	user := &telegram.User{ID: 200}
+	var updates []telegram.Update
+	for i := int64(1); i <= n; i++ {
+		// 同一用户分布在不同会话中，会被分配到不同的 worker 并发处理
+		chat := &telegram.Chat{ID: i % 5, Type: "group"}
+		updates = append(updates, textUpdate(i, chat, user, "hi"))
+	}
+
+	f := newFakeTelegram(t, updates...)
+	b := f.newBot(&BotOptional{Workers: 4})
+	store := NewMemorySessionStore()
+	b.SetSessionStore(store, &SessionOptional{KeyFunc: SessionKeyAtUser})
+
+	type counter struct {
+		Count int `json:"count"`
+	}
+	got := make(chan string, n)
+	b.SetMessageProcessor(func(c *Context) error {
+		var s counter
+		if err := c.Session(&s); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+		s.Count++
+		got <- "ok"
+		return nil
+	})
+	runBot(t, b)
+	collect(t, got, n)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, err := store.Get("user:200")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) == `{"count":50}` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("并发更新后会话数据错误: %s", v)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBot_SessionPanic(t *testing.T) {
+	user := &telegram.User{ID: 200}
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, user, "panic"),
+		textUpdate(2, chat, user, "check"),
+	)
+	b := f.newBot(nil)
+	store := NewMemorySessionStore()
+	b.SetSessionStore(store, &SessionOptional{KeyFunc: SessionKeyAtUser})
+	b.SetErrorHandler(func(err error, update *telegram.Update) {})
+
+	got := make(chan string, 2)
+	b.SetMessageProcessor(func(c *Context) error {
+		s := map[string]int{}
+		if err := c.Session(&s); err != nil {
+			return err
+		}
+		if c.Message.Text == "panic" {
+			s["debit"] = 1
+			panic("boom")
+		}
+		_, ok := s["debit"]
+		got <- fmt.Sprint(c.Message.Text, ":", ok)
+		return nil
+	})
+	runBot(t, b)
+
+	// 第二个更新能够加载会话数据说明 panic 后锁已释放
+	if result := collect(t, got, 1); result[0] != "check:false" {
+		t.Fatalf("panic 时的修改不应保存: %v", result)
+	}
+	if v, err := store.Get("user:200"); err != nil || strings.Contains(string(v), "debit") {
+		t.Fatalf("会话数据 %s %v 与预期不一致", v, err)
+	}
+}
+
+// countingSessionStore 记录写入次数的会话数据存储
+type countingSessionStore struct {
+	*MemorySessionStore
+	mu   sync.Mutex
+	sets int
+}
+
+func (s *countingSessionStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	s.sets++
+	s.mu.Unlock()
+	return s.MemorySessionStore.Set(key, value, ttl)
+}
+
+func TestBot_SessionRefresh(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtPrivate}
+	user := &telegram.User{ID: 200}
+	cases := []struct {
+		refresh time.Duration
+		want    int
+	}{
+		{0, 1},               // 默认为 TTL 的十分之一，只在第一次（没有保存记录时）刷新有效期
+		{time.Nanosecond, 3}, // 每次都超过刷新间隔
+	}
+	for _, tc := range cases {
+		var updates []telegram.Update
+		for i := int64(1); i <= 3; i++ {
+			updates = append(updates, textUpdate(i, chat, user, "hi"))
+		}
+		f := newFakeTelegram(t, updates...)
+		b := f.newBot(nil)
+		store := &countingSessionStore{MemorySessionStore: NewMemorySessionStore()}
+		_ = store.MemorySessionStore.Set("chat:100:user:200", []byte(`{"n":1}`), time.Hour)
+		b.SetSessionStore(store, &SessionOptional{TTL: time.Hour, RefreshInterval: tc.refresh})
+
+		got := make(chan string, 3)
+		b.SetMessageProcessor(func(c *Context) error {
+			var s struct {
+				N int `json:"n"`
+			}
+			if err := c.Session(&s); err != nil {
+				return err
+			}
+			got <- fmt.Sprint(s.N)
+			return nil
+		})
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- b.Run()
+		}()
+		collect(t, got, 3)
+		if err := b.Shutdown(context.Background()); err != nil { // 等待处理结束（会话数据已保存）
+			t.Fatal(err)
+		}
+		if err := <-runErr; err != ErrBotClosed {
+			t.Fatal(err)
+		}
+
+		store.mu.Lock()
+		sets := store.sets
+		store.mu.Unlock()
+		if sets != tc.want {
+			t.Fatalf("RefreshInterval %v: 数据没有变化时写入 %d 次，预期 %d 次", tc.refresh, sets, tc.want)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/elissa2333/httpc"
@@ -13,6 +15,8 @@ type API struct {
 	Token string // 令牌
 
 	HTTPClient *httpc.Client // http 客户端
+
+	ctx context.Context // 请求所使用的上下文
 }
 
 // New 新建 API 调用器
@@ -32,3 +36,72 @@ func New(httpClient *http.Client, id int, token string) *API {
 
 	return b
 }
+
+// WithContext 返回一个使用 ctx 发起请求的 API 副本，ctx 被取消或超时后正在进行的请求会被中断
+func (a API) WithContext(ctx context.Context) *API {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	client := *a.HTTPClient
+	base := client.Client.Transport
+	if t, ok := base.(*contextTransport); ok { // 避免重复包裹
+		base = t.base
+	}
+	client.Client.Transport = &contextTransport{ctx: ctx, base: base}
+
+	a.HTTPClient = &client
+	a.ctx = ctx
+	return &a
+}
+
+// Context 获取请求所使用的上下文（未设置时为 context.Background）
+func (a API) Context() context.Context {
+	if a.ctx != nil {
+		return a.ctx
+	}
+	return context.Background()
+}
+
+// contextTransport 为每个请求附加指定上下文
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return base.RoundTrip(req.WithContext(t.ctx))
+	}
+
+	// 保留 http.Client 自身的超时设置
+	ctx, cancel := context.WithDeadline(t.ctx, deadline)
+	res, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+
+	return res, nil
+}
+
+// cancelOnCloseBody 关闭响应体时释放上下文
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 实现 io.Closer 接口
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -8,9 +8,12 @@ Bot API中的所有方法都不区分大小写。我们支持GET和POST HTTP方
 https://core.telegram.org/bots/api#available-methods*/
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 
 	"github.com/elissa2333/httpc"
 
@@ -645,13 +648,27 @@ func (a API) GetFile(fileID string) (*File, error) {
 
 // handleOptional 处理可选参数
 func (a API) handleOptional(url string, m map[string]interface{}, optional interface{}, result interface{}) error {
+	m, err := BuildParams(m, optional)
+	if err != nil {
+		return err
+	}
+
+	res, err := a.HTTPClient.SetBody(m).Post(url)
+	if err != nil {
+		return err
+	}
+	return HandleResp(res, result)
+}
+
+// BuildParams 合并必填参数与可选参数结构体（可为 nil），并删除值为 nil 的参数
+func BuildParams(m map[string]interface{}, optional interface{}) (map[string]interface{}, error) {
 	if m == nil {
 		m = map[string]interface{}{}
 	}
 
 	o, err := utils.StructToMap(optional)
 	if err != nil && err.Error() != "input data is nil" && err.Error() != "input data type is not a struct" {
-		return err
+		return nil, err
 	}
 
 	for k, v := range o {
@@ -664,7 +681,44 @@ func (a API) handleOptional(url string, m map[string]interface{}, optional inter
 		}
 	}
 
-	res, err := a.HTTPClient.SetBody(m).Post(url)
+	return m, nil
+}
+
+// Call 按方法名调用 API，用于调用尚未封装的方法或需要在运行时决定调用哪个方法的场景
+// params 为参数（以 JSON 发送，不支持上传文件），optional 为可选参数结构体（可为 nil），结果写入 result（可为 nil）
+func (a API) Call(method string, params map[string]interface{}, optional interface{}, result interface{}) error {
+	return a.handleOptional("/"+method, params, optional, result)
+}
+
+// Upload 按方法名调用需要上传文件的 API（以 multipart/form-data 发送），用法同 Call
+// params 中 io.Reader 类型的值作为文件上传（optional 中的文件会被忽略），字符串与数字原样发送，其他值（如 reply_markup）以 JSON 格式发送
+func (a API) Upload(method string, params map[string]interface{}, optional interface{}, result interface{}) error {
+	m, err := BuildParams(params, optional)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]httpc.FromDataRow, 0, len(m))
+	for k, v := range m {
+		switch value := v.(type) {
+		case io.Reader:
+			rows = append(rows, httpc.FromDataRow{Key: k, Value: k, Data: value})
+		case string:
+			rows = append(rows, httpc.FromDataRow{Key: k, Value: value})
+		case bool, int, int64:
+			rows = append(rows, httpc.FromDataRow{Key: k, Value: fmt.Sprint(value)})
+		case float64: // 可选参数结构体中的数字
+			rows = append(rows, httpc.FromDataRow{Key: k, Value: strconv.FormatFloat(value, 'f', -1, 64)})
+		default:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, httpc.FromDataRow{Key: k, Value: string(b)})
+		}
+	}
+
+	res, err := a.HTTPClient.SetFromData(rows...).Post("/" + method)
 	if err != nil {
 		return err
 	}
@@ -1030,3 +1084,37 @@ func (a API) GetMyCommands() ([]BotCommand, error) {
 	err = HandleResp(res, &result)
 	return result, err
 }
+
+// MyCommandsOptional SetMyCommandsWithOptional、GetMyCommandsWithOptional、DeleteMyCommands 可选参数
+type MyCommandsOptional struct {
+	Scope        interface{} `json:"scope,omitempty"`         // 命令列表的适用范围（BotCommandScope 的 JSON 序列化对象），默认为所有私聊
+	LanguageCode string      `json:"language_code,omitempty"` // 两个字母的 ISO 639-1 语言代码。为空时作用于没有专属命令列表的所有用户
+}
+
+// SetMyCommandsWithOptional 更改指定范围和语言的 bot 命令列表，commands 为空时设置为空列表
+// https://core.telegram.org/bots/api#setmycommands
+func (a API) SetMyCommandsWithOptional(commands []BotCommand, optional *MyCommandsOptional) (bool, error) {
+	if commands == nil {
+		commands = []BotCommand{}
+	}
+
+	var result bool
+	err := a.handleOptional("/setMyCommands", map[string]interface{}{"commands": commands}, optional, &result)
+	return result, err
+}
+
+// GetMyCommandsWithOptional 获取指定范围和语言的 bot 命令列表
+// https://core.telegram.org/bots/api#getmycommands
+func (a API) GetMyCommandsWithOptional(optional *MyCommandsOptional) ([]BotCommand, error) {
+	var result []BotCommand
+	err := a.handleOptional("/getMyCommands", nil, optional, &result)
+	return result, err
+}
+
+// DeleteMyCommands 删除指定范围和语言的 bot 命令列表，之后用户将看到更上层范围的命令列表
+// https://core.telegram.org/bots/api#deletemycommands
+func (a API) DeleteMyCommands(optional *MyCommandsOptional) (bool, error) {
+	var result bool
+	err := a.handleOptional("/deleteMyCommands", nil, optional, &result)
+	return result, err
+}
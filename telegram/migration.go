@@ -0,0 +1,239 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// MigratedChatID 获取因群组升级为超级群组而失败的请求中新的超级群组 ID
+func MigratedChatID(err error) (int64, bool) {
+	var r *Response
+	if errors.As(err, &r) && r.Parameters != nil && r.Parameters.MigrateToChatID != 0 {
+		return r.Parameters.MigrateToChatID, true
+	}
+	return 0, false
+}
+
+// ChatMigratedFunc 群组升级处理函数，fromChatID 为请求中的原群组 ID（无法获取时为0）
+type ChatMigratedFunc func(fromChatID, toChatID int64)
+
+// MigrationOptional HandleChatMigration 可选参数
+type MigrationOptional struct {
+	Retry      bool             // 是否将 chat_id 替换为新的超级群组 ID 后重试一次（不替换 from_chat_id 等其他参数）
+	OnMigrated ChatMigratedFunc // 请求因群组升级而失败时调用（每个失败的请求调用一次）
+}
+
+// HandleChatMigration 处理因群组升级为超级群组而失败的请求（错误中包含 migrate_to_chat_id 参数）
+// 不重试或重试失败时返回的错误可以通过 MigratedChatID 获取新的超级群组 ID，重复调用时替换之前的设置
+func (a *API) HandleChatMigration(optional *MigrationOptional) {
+	client := *a.HTTPClient
+	base := client.Client.Transport
+	if t, ok := base.(*contextTransport); ok {
+		base = t.base
+	}
+	if t, ok := base.(*migrationTransport); ok { // 避免重复包裹
+		base = t.base
+	}
+
+	var transport http.RoundTripper = base
+	if optional != nil {
+		transport = &migrationTransport{base: base, retry: optional.Retry, onMigrated: optional.OnMigrated}
+	}
+	if t, ok := client.Client.Transport.(*contextTransport); ok {
+		transport = &contextTransport{ctx: t.ctx, base: transport}
+	}
+	client.Client.Transport = transport
+
+	a.HTTPClient = &client
+}
+
+// migrationTransport 检测因群组升级而失败的请求
+type migrationTransport struct {
+	base       http.RoundTripper
+	retry      bool
+	onMigrated ChatMigratedFunc
+}
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (t *migrationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		return res, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	m := &Response{}
+	if json.Unmarshal(body, m) != nil || m.Parameters == nil || m.Parameters.MigrateToChatID == 0 {
+		return res, nil
+	}
+	to := m.Parameters.MigrateToChatID
+
+	from, retryReq := rewriteChatID(req, to)
+	if t.onMigrated != nil {
+		t.onMigrated(from, to)
+	}
+	if !t.retry || retryReq == nil {
+		return res, nil
+	}
+
+	return base.RoundTrip(retryReq)
+}
+
+// rewriteChatID 复制请求并将参数 chat_id 替换为 to，返回原来的 chat_id，无法替换时请求为 nil
+// 支持 JSON、表单与 multipart 表单格式的请求
+func rewriteChatID(req *http.Request, to int64) (int64, *http.Request) {
+	if req.GetBody == nil {
+		return 0, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return 0, nil
+	}
+	body, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return 0, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return 0, nil
+	}
+
+	var from int64
+	switch mediaType {
+	case "application/json":
+		from, body, err = rewriteJSONChatID(body, to)
+	case "application/x-www-form-urlencoded":
+		from, body, err = rewriteFormChatID(body, to)
+	case "multipart/form-data":
+		from, body, err = rewriteMultipartChatID(body, params["boundary"], to)
+	default:
+		return 0, nil
+	}
+	if err != nil || from == 0 {
+		return from, nil
+	}
+
+	retryReq := req.Clone(req.Context())
+	retryReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	retryReq.ContentLength = int64(len(body))
+	retryReq.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return from, retryReq
+}
+
+// errNoChatID 请求中没有数字格式的 chat_id
+var errNoChatID = errors.New("no numeric chat_id")
+
+// rewriteJSONChatID 替换 JSON 中的 chat_id，保留原来的格式（数字或字符串）
+func rewriteJSONChatID(body []byte, to int64) (int64, []byte, error) {
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return 0, nil, err
+	}
+
+	var from int64
+	raw := m["chat_id"]
+	if err := json.Unmarshal(raw, &from); err == nil {
+		m["chat_id"] = json.RawMessage(strconv.FormatInt(to, 10))
+	} else {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, nil, errNoChatID
+		}
+		if from, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, nil, errNoChatID
+		}
+		m["chat_id"] = json.RawMessage(strconv.Quote(strconv.FormatInt(to, 10)))
+	}
+
+	body, err := json.Marshal(m)
+	return from, body, err
+}
+
+// rewriteFormChatID 替换表单中的 chat_id
+func rewriteFormChatID(body []byte, to int64) (int64, []byte, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	from, err := strconv.ParseInt(values.Get("chat_id"), 10, 64)
+	if err != nil {
+		return 0, nil, errNoChatID
+	}
+	values.Set("chat_id", strconv.FormatInt(to, 10))
+	return from, []byte(values.Encode()), nil
+}
+
+// rewriteMultipartChatID 替换 multipart 表单中的 chat_id，其他部分原样复制
+func rewriteMultipartChatID(body []byte, boundary string, to int64) (int64, []byte, error) {
+	if boundary == "" {
+		return 0, nil, errNoChatID
+	}
+
+	var from int64
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	if err := w.SetBoundary(boundary); err != nil {
+		return 0, nil, err
+	}
+	for {
+		part, err := r.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		pw, err := w.CreatePart(part.Header)
+		if err != nil {
+			return 0, nil, err
+		}
+		if part.FormName() == "chat_id" && part.FileName() == "" {
+			value, err := ioutil.ReadAll(part)
+			if err != nil {
+				return 0, nil, err
+			}
+			if from, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return 0, nil, errNoChatID
+			}
+			if _, err := pw.Write([]byte(strconv.FormatInt(to, 10))); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if _, err := io.Copy(pw, part); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, nil, err
+	}
+	if from == 0 {
+		return 0, nil, errNoChatID
+	}
+	return from, buf.Bytes(), nil
+}
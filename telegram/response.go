@@ -9,8 +9,16 @@ type Response struct {
 	Ok     bool        `json:"ok"` // 是否请求成功
 	Result interface{} // 响应结果
 
-	ErrorCode   int    `json:"error_code"`  // 错误状态码
-	Description string `json:"description"` // 错误说明
+	ErrorCode   int                 `json:"error_code"`           // 错误状态码
+	Description string              `json:"description"`          // 错误说明
+	Parameters  *ResponseParameters `json:"parameters,omitempty"` // 可选的。说明请求为何失败，用于自动处理错误
+}
+
+// ResponseParameters 响应参数，说明请求为何失败
+// https://core.telegram.org/bots/api#responseparameters
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"` // 可选的。群组已升级为超级群组，新的超级群组 ID
+	RetryAfter      int   `json:"retry_after,omitempty"`        // 可选的。超出频率限制时，需要等待的秒数
 }
 
 // Error 实现 error 接口包裹错误
@@ -60,6 +60,8 @@ type Chat struct {
 // https://core.telegram.org/bots/api#message
 type Message struct {
 	MessageID               int64                    `json:"message_id,omitempty"`              // 此聊天中的唯一消息标识符
+	MessageThreadID         int64                    `json:"message_thread_id,omitempty"`       // 可选的。消息所属话题的标识符，仅用于超级群组
+	IsTopicMessage          bool                     `json:"is_topic_message,omitempty"`        // 可选的。消息是否发送在论坛话题中
 	From                    *User                    `json:"from,omitempty"`                    // 可选的。发件人，对于发送到渠道的消息为空
 	SenderChat              *Chat                    `json:"sender_chat"`                       // 可选的。消息发送方，代表聊天室发送。频道本身用于频道消息。超组本身用于接收来自匿名组管理员的消息。消息的链接通道自动转发到讨论组
 	Data                    int64                    `json:"data,omitempty"`                    // 消息在Unix时间中发送的日期
@@ -95,7 +97,7 @@ type Message struct {
 	Location                *Location                `json:"location,omitempty"`                // 可选的。消息是共享位置，有关位置的信息
 	NewChatMembers          []User                   `json:"new_chat_members,omitempty"`        // 可选的。添加到组或超组中的新成员以及有关它们的信息（机器人本身可能是这些成员之一）
 	LeftChatMember          *User                    `json:"left_chat_member,omitempty"`        // 可选的。成员已从群组中删除，有关他们的信息（该成员可能是漫游器本身）
-	EwChatTitle             string                   `json:"ew_chat_title,omitempty"`           // 可选的。聊天标题已更改为此值
+	EwChatTitle             string                   `json:"new_chat_title,omitempty"`          // 可选的。聊天标题已更改为此值（字段名沿用历史拼写以保持兼容）
 	NewChatPhoto            []PhotoSize              `json:"new_chat_photo,omitempty"`          // 可选的。聊天照片已更改为此值
 	DeleteChatPhoto         bool                     `json:"delete_chat_photo,omitempty"`       // 可选的。服务消息：聊天照片已删除
 	GroupChatCreated        bool                     `json:"group_chat_created,omitempty"`      // 可选的。服务信息：组已创建
@@ -354,12 +356,14 @@ type ReplyKeyboardMarkup struct {
 }
 
 // KeyboardButton 回复键盘的一个按钮。对于简单的文本按钮，可以使用String代替此对象来指定按钮的文本。可选字段request_contact，request_location和request_poll是互斥的。
+// 注意：RequestPoll 由值类型改为 *KeyboardButtonPollType（值类型在 omitempty 下仍会输出空的 request_poll，使按钮变为投票按钮），
+// 这是不兼容的变更，原先以值赋值的代码需要改为取地址，例如 RequestPoll: &KeyboardButtonPollType{Type: "quiz"}
 // https://core.telegram.org/bots/api#keyboardbutton
 type KeyboardButton struct {
-	Text            string                 `json:"text,omitempty"`             // 按钮的文字。如果未使用任何可选字段，则在按下按钮时它将作为消息发送
-	RequestContact  bool                   `json:"request_contact,omitempty"`  // 可选的。如果为True，则当按下按钮时，用户的电话号码将作为联系人发送。仅在私人聊天中可用
-	RequestLocation bool                   `json:"request_location,omitempty"` // 可选的。如果为True，则在按下按钮时将发送用户的当前位置。仅在私人聊天中可用
-	RequestPoll     KeyboardButtonPollType `json:"request_poll,omitempty"`     // 可选的。如果指定，则将要求用户创建一个民意调查，并在按下按钮时将其发送给机器人。仅在私人聊天中可用
+	Text            string                  `json:"text,omitempty"`             // 按钮的文字。如果未使用任何可选字段，则在按下按钮时它将作为消息发送
+	RequestContact  bool                    `json:"request_contact,omitempty"`  // 可选的。如果为True，则当按下按钮时，用户的电话号码将作为联系人发送。仅在私人聊天中可用
+	RequestLocation bool                    `json:"request_location,omitempty"` // 可选的。如果为True，则在按下按钮时将发送用户的当前位置。仅在私人聊天中可用
+	RequestPoll     *KeyboardButtonPollType `json:"request_poll,omitempty"`     // 可选的。如果指定，则将要求用户创建一个民意调查，并在按下按钮时将其发送给机器人。仅在私人聊天中可用
 }
 
 // KeyboardButtonPollType 民意调查的类型，可以在按下相应按钮时创建和发送该民意调查。
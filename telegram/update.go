@@ -15,6 +15,31 @@ import (
 	"github.com/elissa2333/tgbot/utils"
 )
 
+const (
+	// UpdateTypeAtMessage 新消息
+	UpdateTypeAtMessage = "message"
+	// UpdateTypeAtEditedMessage 已编辑的消息
+	UpdateTypeAtEditedMessage = "edited_message"
+	// UpdateTypeAtChannelPost 频道帖子
+	UpdateTypeAtChannelPost = "channel_post"
+	// UpdateTypeAtEditedChannelPost 已编辑的频道帖子
+	UpdateTypeAtEditedChannelPost = "edited_channel_post"
+	// UpdateTypeAtInlineQuery 内联查询
+	UpdateTypeAtInlineQuery = "inline_query"
+	// UpdateTypeAtChosenInlineResult 已选择的内联结果
+	UpdateTypeAtChosenInlineResult = "chosen_inline_result"
+	// UpdateTypeAtCallbackQuery 回调查询
+	UpdateTypeAtCallbackQuery = "callback_query"
+	// UpdateTypeAtShippingQuery 收货查询
+	UpdateTypeAtShippingQuery = "shipping_query"
+	// UpdateTypeAtPreCheckoutQuery 预结帐查询
+	UpdateTypeAtPreCheckoutQuery = "pre_checkout_query"
+	// UpdateTypeAtPoll 投票状态
+	UpdateTypeAtPoll = "poll"
+	// UpdateTypeAtPollAnswer 投票答案
+	UpdateTypeAtPollAnswer = "poll_answer"
+)
+
 // Update 该对象表示传入的更新。
 //最多一个可选参数可以出现在任何给定的更新。
 // https://core.telegram.org/bots/api#update
@@ -54,12 +79,13 @@ type WebhookOptional struct {
 	MaxConnections     int       `json:"max_connections,omitempty"` // 与Webhook进行更新交付的同时HTTPS连接的最大允许数量为1-100。默认为40。使用较低的值可以限制bot服务器的负载，使用较高的值可以增加bot的吞吐量。
 	AllowedUpdates     []string  `json:"allowed_updates,omitempty"` // 您希望机器人接收的更新类型的JSON序列化列表。例如，指定[“ message”，“ edited_channel_post”，“ callback_query”]仅接收这些类型的更新。请参阅更新以获取可用更新类型的完整列表。指定一个空列表以接收所有更新，无论类型如何（默认）。如果未指定，将使用以前的设置。
 	DropPendingUpdates bool      `json:"drop_pending_updates"`      // 传递True以删除所有待处理的更新
+	SecretToken        string    `json:"secret_token,omitempty"`    // 1-256个字符（A-Z、a-z、0-9、_ 和 -），每个 webhook 请求都会在请求头 X-Telegram-Bot-Api-Secret-Token 中携带该值，以确认请求来自您设置的 webhook
 }
 
 // SetWebhook 指定URL并通过传出的Webhook接收传入的更新。只要该漫游器有更新，我们就会向指定的URL发送一个HTTPS POST请求，其中包含JSON序列化的Update。如果请求失败，我们将在合理的尝试后放弃。成功返回True。
 //如果您想确保Webhook请求来自Telegram，建议您在URL中使用秘密路径，例如 `https://www.example.com/<token>`。由于没有其他人知道您的漫游器令牌，因此您可以确定它是我们。
 // https://core.telegram.org/bots/api#setwebhook
-func (a *API) SetWebhook(url string, optional *WebhookOptional) error { // TODO 仅允许 telegram 的 ip 进行访问 我直接监听指定的URI
+func (a *API) SetWebhook(url string, optional *WebhookOptional) error {
 	var rows []httpc.FromDataRow
 	rows = append(rows, httpc.FromDataRow{
 		Key:   "url",
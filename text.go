@@ -0,0 +1,59 @@
+package tgbot
+
+import (
+	"regexp"
+)
+
+// HandleText 添加按正则匹配消息文本的处理器，子匹配可以通过 Context.Matches 与 Context.Match 获取，pattern 为 nil 时只按 filters 判断
+//
+// 消息先交给会话处理，之后按优先级（通过 Group.Priority 设置，默认为0）从高到低、同一优先级中按以下顺序交给第一个匹配的处理器：
+// 命令处理器、默认命令处理器、文本处理器、指定类型消息处理器、默认消息处理器，同一类处理器按添加顺序。
+// 处理器返回 ErrContinue 时继续交给下一个匹配的处理器，否则停止传递
+func (b *Bot) HandleText(pattern *regexp.Regexp, fn MessageProcessorFunc, filters ...Filter) {
+	b.handleText(0, pattern, fn, filters)
+}
+
+// handleText 添加指定优先级的文本处理器
+func (b *Bot) handleText(priority int, pattern *regexp.Regexp, fn MessageProcessorFunc, filters []Filter) {
+	filter := And(filters...)
+	b.messageHandlers.add(&handler{
+		priority: priority,
+		stage:    handlerStageAtText,
+		match: func(c *Context) bool {
+			if c.Message == nil || c.Message.Text == "" {
+				return false
+			}
+
+			var m []string
+			if pattern != nil {
+				if m = pattern.FindStringSubmatch(c.Message.Text); m == nil {
+					return false
+				}
+			}
+			if !filter(c) {
+				return false
+			}
+			c.pattern, c.matches = pattern, m
+			return true
+		},
+		run:   b.invoker(fn),
+		label: "HandleText",
+	})
+}
+
+// Matches 获取通过 HandleText 或 AddCallbackPattern 匹配时的子匹配（第一个元素为完整的匹配）
+func (c *Context) Matches() []string {
+	return c.matches
+}
+
+// Match 获取命名子匹配（(?P<name>...)），不存在时返回空字符串
+func (c *Context) Match(name string) string {
+	if c.pattern == nil {
+		return ""
+	}
+	i := c.pattern.SubexpIndex(name)
+	if i < 0 || i >= len(c.matches) {
+		return ""
+	}
+	return c.matches[i]
+}
@@ -0,0 +1,113 @@
+package tgbot
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+func TestFilter(t *testing.T) {
+	private := &telegram.Chat{ID: 1, Type: telegram.ChatTypeAtPrivate}
+	group := &telegram.Chat{ID: 2, Type: telegram.ChatTypeAtSuperGroup}
+	msg := func(chat *telegram.Chat, m telegram.Message) *Context {
+		m.Chat, m.From = chat, &telegram.User{ID: 7}
+		return &Context{Message: &m, Update: &telegram.Update{Message: &m}}
+	}
+
+	cases := []struct {
+		name   string
+		filter Filter
+		ctx    *Context
+		want   bool
+	}{
+		{"private", Private(), msg(private, telegram.Message{}), true},
+		{"group", GroupChat(), msg(private, telegram.Message{}), false},
+		{"from", And(GroupChat(), FromUser(7)), msg(group, telegram.Message{}), true},
+		{"not from", Not(FromUser(8)), msg(group, telegram.Message{}), true},
+		{"reply", HasReply(), msg(group, telegram.Message{ReplyToMessage: &telegram.Message{}}), true},
+		{"entity", HasEntity(telegram.MessageEntityAtURL), msg(group, telegram.Message{CaptionEntities: []telegram.MessageEntity{{Type: telegram.MessageEntityAtURL}}}), true},
+		{"forwarded", Or(Forwarded(), HasReply()), msg(group, telegram.Message{}), false},
+	}
+	for _, c := range cases {
+		if got := c.filter(c.ctx); got != c.want {
+			t.Fatalf("%s: 结果 %v 与预期 %v 不一致", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBot_HandleText(t *testing.T) {
+	chat := &telegram.Chat{ID: 100, Type: telegram.ChatTypeAtGroup}
+	f := newFakeTelegram(t,
+		textUpdate(1, chat, &telegram.User{ID: 1}, "price btc"),
+		textUpdate(2, chat, &telegram.User{ID: 1}, "ban spammer"),
+		textUpdate(3, chat, &telegram.User{ID: 9}, "ban spammer"),
+		textUpdate(4, chat, &telegram.User{ID: 1}, "hello"),
+	)
+	b := f.newBot(nil)
+	got := make(chan string, 8)
+	b.HandleText(regexp.MustCompile(`^price (?P<symbol>\w+)$`), func(c *Context) error {
+		got <- "price:" + c.Match("symbol")
+		return nil
+	})
+	b.Group(Where(GroupChat())).HandleText(regexp.MustCompile(`^ban (\w+)$`), func(c *Context) error {
+		got <- "ban:" + c.Matches()[1]
+		return nil
+	}, FromUser(9))
+	b.SetMessageProcessor(func(c *Context) error {
+		got <- "default:" + c.Message.Text
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"price:btc", "default:ban spammer", "ban:spammer", "default:hello"}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+}
+
+func TestBot_WhereFallthrough(t *testing.T) {
+	private := &telegram.Chat{ID: 1, Type: telegram.ChatTypeAtPrivate}
+	group := &telegram.Chat{ID: 2, Type: telegram.ChatTypeAtGroup}
+	user := &telegram.User{ID: 9}
+	f := newFakeTelegram(t,
+		textUpdate(1, private, user, "ban spammer"),
+		textUpdate(2, group, user, "ban spammer"),
+		telegram.Update{UpdateID: 3, CallbackQuery: &telegram.CallbackQuery{ID: "q3", From: user, Message: &telegram.Message{MessageID: 1, Chat: private}, Data: "vote"}},
+	)
+	b := f.newBot(&BotOptional{Workers: 1})
+	errs := make(chan string, 4)
+	b.SetErrorHandler(func(err error, update *telegram.Update) {
+		errs <- err.Error()
+	})
+	got := make(chan string, 8)
+	groups := b.Group(Where(GroupChat()))
+	groups.HandleText(regexp.MustCompile(`^ban (\w+)$`), func(c *Context) error {
+		got <- "ban:" + c.Matches()[1]
+		return nil
+	})
+	groups.AddCallback("vote", func(c *CallbackQueryContext) error {
+		got <- "vote"
+		return nil
+	})
+	b.SetMessageProcessor(func(c *Context) error {
+		got <- "default:" + c.Message.Text
+		return nil
+	})
+	b.SetCallbackQueryProcessor(func(c *CallbackQueryContext) error {
+		got <- "callback:" + c.Data
+		return nil
+	})
+	runBot(t, b)
+
+	want := []string{"default:ban spammer", "ban:spammer", "callback:vote"}
+	if result := collect(t, got, len(want)); !reflect.DeepEqual(result, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", result, want)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("ErrContinue 不应交给错误处理函数: %s", err)
+	default:
+	}
+}
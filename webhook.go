@@ -0,0 +1,503 @@
+package tgbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	stdURL "net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elissa2333/httpc"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// WebhookServerOptional 内置 webhook 服务可选参数
+type WebhookServerOptional struct {
+	Server *http.Server // 自定义服务，运行时复制其设置而不修改该结构体。Handler 为空时使用 webhook 处理器，Addr 为空时使用 SetWebhook 的监听地址
+
+	CertFile string // TLS 证书文件，与 KeyFile 同时设置时使用 HTTPS 提供服务
+	KeyFile  string // TLS 私钥文件
+
+	ReadTimeout     time.Duration // 读取请求的超时时间，为0时不限制
+	WriteTimeout    time.Duration // 写入响应的超时时间，为0时不限制
+	ShutdownTimeout time.Duration // 停止时等待正在接收的更新请求结束的时间，为0时一直等待
+}
+
+// SetWebhook 设置 webhook
+// address 为空时不启动内置服务，需要通过 WebhookHandler 自行挂载到已有的路由上
+func (b *Bot) SetWebhook(url string /*API 访问地址*/, address string /*本地监听地址*/, optional *telegram.WebhookOptional) error {
+	return b.SetWebhookWithServer(url, address, optional, nil)
+}
+
+// SetWebhookWithServer 设置 webhook 并指定内置服务的参数（TLS、超时、自定义 http.Server）
+func (b *Bot) SetWebhookWithServer(url string /*API 访问地址*/, address string /*本地监听地址*/, optional *telegram.WebhookOptional, serverOptional *WebhookServerOptional) error {
+	parseURL, err := stdURL.Parse(url)
+	if err != nil {
+		return err
+	}
+
+	if err := b.API.SetWebhook(url, optional); err != nil {
+		return err
+	}
+
+	b.webhookSecretToken = ""
+	if optional != nil {
+		b.webhookSecretToken = optional.SecretToken
+	}
+
+	if address == "" && (serverOptional == nil || serverOptional.Server == nil) { // 由调用者挂载 WebhookHandler
+		b.webHookEngine = func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}
+		return nil
+	}
+
+	pattern := parseURL.Path
+	if pattern == "" {
+		pattern = "/"
+	}
+
+	b.webHookEngine = func(ctx context.Context) error {
+		return b.serveWebhook(ctx, pattern, address, serverOptional)
+	}
+
+	return nil
+}
+
+// cloneServer 根据调用者提供的服务创建新的服务，不修改调用者的结构体（base 为 nil 时返回空服务）
+func cloneServer(base *http.Server) *http.Server {
+	if base == nil {
+		return &http.Server{}
+	}
+	return &http.Server{
+		Addr:              base.Addr,
+		Handler:           base.Handler,
+		TLSConfig:         base.TLSConfig,
+		ReadTimeout:       base.ReadTimeout,
+		ReadHeaderTimeout: base.ReadHeaderTimeout,
+		WriteTimeout:      base.WriteTimeout,
+		IdleTimeout:       base.IdleTimeout,
+		MaxHeaderBytes:    base.MaxHeaderBytes,
+		TLSNextProto:      base.TLSNextProto,
+		ConnState:         base.ConnState,
+		ErrorLog:          base.ErrorLog,
+		BaseContext:       base.BaseContext,
+		ConnContext:       base.ConnContext,
+	}
+}
+
+// serveWebhook 运行内置 webhook 服务，ctx 结束后关闭服务
+func (b *Bot) serveWebhook(ctx context.Context, pattern string, address string, optional *WebhookServerOptional) error {
+	if optional == nil {
+		optional = &WebhookServerOptional{}
+	}
+
+	server := cloneServer(optional.Server)
+	if server.Addr == "" {
+		server.Addr = address
+	}
+	if server.Handler == nil {
+		mux := http.NewServeMux()
+		mux.Handle(pattern, b.WebhookHandler())
+		server.Handler = mux
+	}
+	if optional.ReadTimeout != 0 {
+		server.ReadTimeout = optional.ReadTimeout
+	}
+	if optional.WriteTimeout != 0 {
+		server.WriteTimeout = optional.WriteTimeout
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if optional.CertFile != "" && optional.KeyFile != "" {
+			errCh <- server.ListenAndServeTLS(optional.CertFile, optional.KeyFile)
+		} else {
+			errCh <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx := context.Background()
+	if optional.ShutdownTimeout != 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, optional.ShutdownTimeout)
+		defer cancel()
+	}
+
+	return server.Shutdown(shutdownCtx) // 等待正在接收的更新请求结束
+}
+
+// WebhookHandler 获取 webhook 处理器，可以挂载到任意路由上
+// 接收到的更新交给与长轮询相同的调度器处理，bot 未运行时返回 503，telegram 稍后会重新投递
+func (b *Bot) WebhookHandler() http.Handler {
+	return http.HandlerFunc(b.handleWebhook)
+}
+
+// handleWebhook 处理 telegram 推送的更新
+func (b *Bot) handleWebhook(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+	if reason := b.verifyWebhook(request); reason != "" {
+		b.logger.Printf("webhook: rejected request from %s: %s", request.RemoteAddr, reason)
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if request.Header.Get(httpc.ContentType) != httpc.MIMEJson {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bodyB, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		b.handleUpdateError(nil, fmt.Errorf("webhook: %w", err))
+		return
+	}
+
+	m := telegram.Update{}
+	if err := json.Unmarshal(bodyB, &m); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		b.handleUpdateError(nil, fmt.Errorf("webhook: %w", err))
+		return
+	}
+
+	if m.UpdateID == 0 { // 坏请求
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var reply *webhookReply
+	if b.webhookReplyTimeout > 0 {
+		reply = newWebhookReply(b.webhookReplyTimeout)
+	}
+
+	if err := b.dispatch(request.Context(), &m, reply); err != nil { // 队列已满且请求已取消或 bot 已关闭，telegram 稍后会重新投递
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	atomic.AddUint64(&b.webhookMetrics.Accepted, 1)
+
+	if reply != nil {
+		b.writeWebhookReply(writer, &m, reply)
+	}
+}
+
+// SetWebhookReply 启用 webhook 内联响应
+// 启用后处理器可以通过 Respond 将一次 API 调用（如 sendMessage、answerInlineQuery）写入 webhook 的响应中，省去一次请求。
+// 处理器在 timeout 内结束且恰好调用了一次 Respond 时才会写入响应，否则回退为普通的 API 调用。timeout 为0时关闭
+func (b *Bot) SetWebhookReply(timeout time.Duration) {
+	b.webhookReplyTimeout = timeout
+}
+
+// webhookReply webhook 内联响应
+type webhookReply struct {
+	mu     sync.Mutex
+	closed bool                   // 已超时、已写入响应或已回退为 API 调用，之后的调用直接请求 API
+	method string                 // 等待写入响应的方法
+	params map[string]interface{} // 等待写入响应的参数
+
+	timer    *time.Timer
+	finished chan struct{} // 更新处理完毕
+}
+
+// newWebhookReply 新建 webhook 内联响应，超时从此时开始计算
+func newWebhookReply(timeout time.Duration) *webhookReply {
+	return &webhookReply{
+		timer:    time.NewTimer(timeout),
+		finished: make(chan struct{}),
+	}
+}
+
+// finish 通知更新处理完毕
+func (r *webhookReply) finish() {
+	close(r.finished)
+}
+
+// respond 记录 API 调用，第二次调用时回退为普通 API 调用（按调用顺序发送）
+func (r *webhookReply) respond(api *telegram.API, method string, params map[string]interface{}) error {
+	r.mu.Lock()
+	if !r.closed && r.method == "" {
+		r.method, r.params = method, params
+		r.mu.Unlock()
+		return nil
+	}
+
+	r.closed = true
+	err := r.flush(api)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return api.Call(method, params, nil, nil)
+}
+
+// flush 以普通 API 调用发送等待中的调用，调用者需持有锁
+func (r *webhookReply) flush(api *telegram.API) error {
+	if r.method == "" {
+		return nil
+	}
+
+	method, params := r.method, r.params
+	r.method, r.params = "", nil
+	return api.Call(method, params, nil, nil)
+}
+
+// writeWebhookReply 等待处理器结束后写入 webhook 响应，超时则回退为普通 API 调用
+func (b *Bot) writeWebhookReply(writer http.ResponseWriter, update *telegram.Update, reply *webhookReply) {
+	defer reply.timer.Stop()
+
+	timeout := false
+	select {
+	case <-reply.finished:
+	case <-reply.timer.C:
+		timeout = true
+	}
+
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+	reply.closed = true
+
+	if timeout {
+		if err := reply.flush(b.API); err != nil {
+			b.handleUpdateError(update, fmt.Errorf("webhook reply: %w", err))
+		}
+		return
+	}
+
+	if reply.method == "" {
+		return
+	}
+
+	reply.params["method"] = reply.method
+	body, err := json.Marshal(reply.params)
+	if err != nil {
+		b.handleUpdateError(update, fmt.Errorf("webhook reply: %w", err))
+		return
+	}
+	reply.method, reply.params = "", nil
+
+	writer.Header().Set(httpc.ContentType, httpc.MIMEJson)
+	_, _ = writer.Write(body)
+}
+
+// Respond 调用 API 方法，由 webhook 接收的更新在启用 SetWebhookReply 时会尽量写入 webhook 响应
+// params 为参数，optional 为可选参数结构体（可为 nil）。写入响应时无法获得调用结果，也无法得知调用是否成功
+func (c *Context) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	params, err := telegram.BuildParams(params, optional)
+	if err != nil {
+		return err
+	}
+	if isAnswerCallbackQuery(method) {
+		c.callbackAnswered = true
+	}
+
+	if c.reply == nil {
+		return c.API.Call(method, params, nil, nil)
+	}
+	return c.reply.respond(c.API, method, params)
+}
+
+// Respond 见 Context.Respond
+func (mcb *MessageContextBase) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return mcb.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *InlineQueryContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *ChosenInlineResultContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *CallbackQueryContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *ShippingQueryContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *PreCheckoutQueryContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *PollContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// Respond 见 Context.Respond
+func (c *PollAnswerContext) Respond(method string, params map[string]interface{}, optional interface{}) error {
+	return c.ctx.Respond(method, params, optional)
+}
+
+// TelegramNetworks telegram 公布的 webhook 请求来源网段
+// https://core.telegram.org/bots/webhooks#the-short-version
+func TelegramNetworks() []*net.IPNet {
+	return ParseNetworks("149.154.160.0/20", "91.108.4.0/22")
+}
+
+// ParseNetworks 解析 CIDR 格式的网段，单个 IP 视为只包含该地址的网段，无法解析的会被忽略
+func ParseNetworks(cidrs ...string) []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+// WebhookSecurity webhook 请求校验，未通过校验的请求返回 403 并写入日志
+type WebhookSecurity struct {
+	SecretToken string // 请求头 X-Telegram-Bot-Api-Secret-Token 必须与之相同，为空时使用 telegram.WebhookOptional.SecretToken
+	SecretPath  string // 请求路径的最后一段必须与之相同，如 https://example.com/bot/<SecretPath>，为空时不校验
+
+	AllowedNetworks []*net.IPNet // 允许的来源网段（如 TelegramNetworks()），为空时不限制
+	TrustedProxies  []*net.IPNet // 受信任的代理，来自这些地址的请求使用 X-Forwarded-For 或 X-Real-IP 中的来源地址
+}
+
+// WebhookMetrics webhook 请求统计
+type WebhookMetrics struct {
+	Accepted       uint64 // 已接收的更新
+	RejectedSecret uint64 // 密钥（请求头或路径）校验失败
+	RejectedIP     uint64 // 来源地址不在允许的网段内
+}
+
+// SetWebhookSecurity 设置 webhook 请求校验
+// 通过 telegram.WebhookOptional.SecretToken 设置了密钥时，即使不调用此方法也会校验请求头
+func (b *Bot) SetWebhookSecurity(security *WebhookSecurity) {
+	b.webhookSecurity = security
+}
+
+// WebhookMetrics 获取 webhook 请求统计
+func (b *Bot) WebhookMetrics() WebhookMetrics {
+	return WebhookMetrics{
+		Accepted:       atomic.LoadUint64(&b.webhookMetrics.Accepted),
+		RejectedSecret: atomic.LoadUint64(&b.webhookMetrics.RejectedSecret),
+		RejectedIP:     atomic.LoadUint64(&b.webhookMetrics.RejectedIP),
+	}
+}
+
+// verifyWebhook 校验 webhook 请求，未通过时返回原因
+func (b *Bot) verifyWebhook(request *http.Request) string {
+	security := b.webhookSecurity
+	if security == nil {
+		security = &WebhookSecurity{}
+	}
+
+	if len(security.AllowedNetworks) != 0 {
+		ip := clientIP(request, security.TrustedProxies)
+		if ip == nil || !containsIP(security.AllowedNetworks, ip) {
+			atomic.AddUint64(&b.webhookMetrics.RejectedIP, 1)
+			return fmt.Sprintf("source address %v is not allowed", ip)
+		}
+	}
+
+	secretToken := security.SecretToken
+	if secretToken == "" {
+		secretToken = b.webhookSecretToken
+	}
+	if secretToken != "" && !secureCompare(request.Header.Get(secretTokenHeader), secretToken) {
+		atomic.AddUint64(&b.webhookMetrics.RejectedSecret, 1)
+		return "invalid secret token"
+	}
+
+	if security.SecretPath != "" && !secureCompare(path.Base(request.URL.Path), security.SecretPath) {
+		atomic.AddUint64(&b.webhookMetrics.RejectedSecret, 1)
+		return "invalid secret path"
+	}
+
+	return ""
+}
+
+// secretTokenHeader telegram 携带密钥的请求头
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// secureCompare 以固定时间比较字符串，避免通过响应时间猜测密钥
+func secureCompare(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// clientIP 获取请求的来源地址
+// 直接连接的地址属于受信任代理时，从 X-Forwarded-For 右侧开始跳过受信任代理，取第一个不受信任的地址，没有时使用 X-Real-IP
+func clientIP(request *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return nil
+			}
+			if !containsIP(trustedProxies, hop) {
+				return hop
+			}
+			ip = hop
+		}
+		return ip
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(request.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP
+	}
+
+	return ip
+}
+
+// containsIP 判断地址是否属于任一网段
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package tgbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/elissa2333/tgbot/telegram"
+)
+
+// testUpdates 覆盖每种更新类型（消息包括命令与文本）
+func testUpdates() []telegram.Update {
+	chat := &telegram.Chat{ID: 100, Type: "private"}
+	channel := &telegram.Chat{ID: 300, Type: "channel"}
+	user := &telegram.User{ID: 200, FirstName: "user"}
+	return []telegram.Update{
+		{UpdateID: 1, Message: &telegram.Message{MessageID: 1, From: user, Chat: chat, Text: "/start now", Entities: []telegram.MessageEntity{{Type: telegram.MessageEntityAtBotCommand, Offset: 0, Length: 6}}}},
+		{UpdateID: 2, Message: &telegram.Message{MessageID: 2, From: user, Chat: chat, Text: "hello"}},
+		{UpdateID: 3, InlineQuery: &telegram.InlineQuery{ID: "q", From: user, Query: "search"}},
+		{UpdateID: 4, CallbackQuery: &telegram.CallbackQuery{ID: "c", From: user, Data: "press"}},
+		{UpdateID: 5, EditedMessage: &telegram.Message{MessageID: 2, From: user, Chat: chat, Text: "hello!"}},
+		{UpdateID: 6, ChannelPost: &telegram.Message{MessageID: 3, Chat: channel, Text: "news"}},
+		{UpdateID: 7, EditedChannelPost: &telegram.Message{MessageID: 3, Chat: channel, Text: "news!"}},
+		{UpdateID: 8, ChosenInlineResult: &telegram.ChosenInlineResult{ResultID: "r1", From: user, Query: "search"}},
+		{UpdateID: 9, ShippingQuery: &telegram.ShippingQuery{ID: "s", From: user, InvoicePayload: "ship"}},
+		{UpdateID: 10, PreCheckoutQuery: &telegram.PreCheckoutQuery{ID: "p", From: user, InvoicePayload: "pay"}},
+		{UpdateID: 11, Poll: &telegram.Poll{ID: "poll", Question: "why"}},
+		{UpdateID: 12, PollAnswer: &telegram.PollAnswer{PollID: "poll", User: user, OptionIds: []int64{1}}},
+	}
+}
+
+// registerRecorders 为每种更新注册记录处理器
+func registerRecorders(b *Bot, ch chan<- string) {
+	b.AddCommandProcessor("/start", func(c *Context) error {
+		ch <- "command:" + c.Message.Text
+		return nil
+	})
+	b.SetMessageProcessorAtText(func(c *TextMessageContext) error {
+		ch <- "text:" + c.Text
+		return nil
+	})
+	b.SetInlineQueryProcessor(func(c *InlineQueryContext) error {
+		ch <- "inline:" + c.Query
+		return nil
+	})
+	b.SetCallbackQueryProcessor(func(c *CallbackQueryContext) error {
+		ch <- "callback:" + c.Data
+		return nil
+	})
+	b.SetEditedMessageProcessor(func(c *EditedMessageContext) error {
+		ch <- "edited:" + c.Message.Text
+		return nil
+	})
+	b.SetChannelPostProcessor(func(c *ChannelPostContext) error {
+		ch <- "channel:" + c.Message.Text
+		return nil
+	})
+	b.SetEditedChannelPostProcessor(func(c *EditedChannelPostContext) error {
+		ch <- "edited_channel:" + c.Message.Text
+		return nil
+	})
+	b.SetChosenInlineResultProcessor(func(c *ChosenInlineResultContext) error {
+		ch <- "chosen:" + c.ResultID
+		return nil
+	})
+	b.SetShippingQueryProcessor(func(c *ShippingQueryContext) error {
+		ch <- "shipping:" + c.InvoicePayload
+		return nil
+	})
+	b.SetPreCheckoutQueryProcessor(func(c *PreCheckoutQueryContext) error {
+		ch <- "pre_checkout:" + c.InvoicePayload
+		return nil
+	})
+	b.SetPollProcessor(func(c *PollContext) error {
+		ch <- "poll:" + c.Question
+		return nil
+	})
+	b.SetPollAnswerProcessor(func(c *PollAnswerContext) error {
+		ch <- fmt.Sprint("poll_answer:", c.PollID, c.OptionIds)
+		return nil
+	})
+}
+
+func TestBot_WebhookAndPollingShareDispatcher(t *testing.T) {
+	n := len(testUpdates())
+
+	// 长轮询
+	polling := make(chan string, 16)
+	pf := newFakeTelegram(t, testUpdates()...)
+	pb := pf.newBot(nil)
+	registerRecorders(pb, polling)
+	runBot(t, pb)
+	pollingResult := collect(t, polling, n)
+
+	// webhook
+	webhook := make(chan string, 16)
+	wf := newFakeTelegram(t)
+	wb := wf.newBot(nil)
+	registerRecorders(wb, webhook)
+	if err := wb.SetWebhook("https://example.com/bot", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	runBot(t, wb)
+	waitRunning(t, wb)
+
+	server := httptest.NewServer(wb.WebhookHandler())
+	defer server.Close()
+	for _, update := range testUpdates() {
+		body, err := json.Marshal(update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("webhook 响应 %d", res.StatusCode)
+		}
+	}
+	webhookResult := collect(t, webhook, n)
+
+	sort.Strings(pollingResult)
+	sort.Strings(webhookResult)
+	if !reflect.DeepEqual(pollingResult, webhookResult) {
+		t.Fatalf("长轮询与 webhook 处理结果不一致\npolling: %v\nwebhook: %v", pollingResult, webhookResult)
+	}
+
+	want := []string{
+		"callback:press", "channel:news", "chosen:r1", "command:now", "edited:hello!", "edited_channel:news!",
+		"inline:search", "poll:why", "poll_answer:poll[1]", "pre_checkout:pay", "shipping:ship", "text:hello",
+	}
+	if !reflect.DeepEqual(pollingResult, want) {
+		t.Fatalf("处理结果 %v 与预期 %v 不一致", pollingResult, want)
+	}
+}
+
+func TestBot_WebhookReply(t *testing.T) {
+	f := newFakeTelegram(t)
+	b := f.newBot(nil)
+	b.SetWebhookReply(200 * time.Millisecond)
+	b.SetMessageProcessor(func(c *Context) error {
+		params := map[string]interface{}{"chat_id": c.GetChatID(), "text": c.Message.Text}
+		switch c.Message.Text {
+		case "twice": // 多于一次调用时全部回退为 API 调用
+			if err := c.Respond("sendMessage", params, nil); err != nil {
+				return err
+			}
+		case "slow": // 超时后回退为 API 调用
+			time.Sleep(400 * time.Millisecond)
+		}
+		return c.Respond("sendMessage", params, nil)
+	})
+	if err := b.SetWebhook("https://example.com/bot", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	runBot(t, b)
+	waitRunning(t, b)
+
+	server := httptest.NewServer(b.WebhookHandler())
+	defer server.Close()
+	post := func(id int64, text string) string {
+		body, _ := json.Marshal(textUpdate(id, &telegram.Chat{ID: 100}, nil, text))
+		res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		return string(data)
+	}
+
+	if body := post(1, "fast"); body != `{"chat_id":"100","method":"sendMessage","text":"fast"}` {
+		t.Fatalf("内联响应错误: %s", body)
+	}
+	if len(f.Calls("sendMessage")) != 0 {
+		t.Fatal("内联响应不应调用 API")
+	}
+
+	if body := post(2, "twice"); body != "" {
+		t.Fatalf("多次调用不应内联响应: %s", body)
+	}
+	if n := len(f.Calls("sendMessage")); n != 2 {
+		t.Fatalf("应回退为 2 次 API 调用，实际 %d 次", n)
+	}
+
+	if body := post(3, "slow"); body != "" {
+		t.Fatalf("超时不应内联响应: %s", body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Calls("sendMessage")) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("超时后未回退为 API 调用")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBot_WebhookSecurity(t *testing.T) {
+	security := &WebhookSecurity{
+		SecretPath:      "path-secret",
+		AllowedNetworks: TelegramNetworks(),
+		TrustedProxies:  ParseNetworks("10.0.0.1"),
+	}
+	tests := []struct {
+		name       string
+		security   *WebhookSecurity
+		token      string // 设置 webhook 时指定的密钥
+		remoteAddr string
+		target     string
+		header     map[string]string
+		status     int // 通过校验的 GET 请求返回 405
+		metrics    WebhookMetrics
+	}{
+		{name: "缺少密钥", token: "token", remoteAddr: "149.154.160.1:443", target: "/bot", status: http.StatusForbidden, metrics: WebhookMetrics{RejectedSecret: 1}},
+		{name: "密钥错误", token: "token", remoteAddr: "149.154.160.1:443", target: "/bot", header: map[string]string{secretTokenHeader: "wrong"}, status: http.StatusForbidden, metrics: WebhookMetrics{RejectedSecret: 1}},
+		{name: "密钥正确", token: "token", remoteAddr: "149.154.160.1:443", target: "/bot", header: map[string]string{secretTokenHeader: "token"}, status: http.StatusMethodNotAllowed},
+		{name: "校验设置的密钥优先", security: &WebhookSecurity{SecretToken: "override"}, token: "token", remoteAddr: "149.154.160.1:443", target: "/bot", header: map[string]string{secretTokenHeader: "token"}, status: http.StatusForbidden, metrics: WebhookMetrics{RejectedSecret: 1}},
+		{name: "路径错误", security: security, remoteAddr: "149.154.160.1:443", target: "/bot/wrong", status: http.StatusForbidden, metrics: WebhookMetrics{RejectedSecret: 1}},
+		{name: "路径正确", security: security, remoteAddr: "149.154.160.1:443", target: "/bot/path-secret", status: http.StatusMethodNotAllowed},
+		{name: "来源地址在允许的网段内", security: security, remoteAddr: "91.108.4.1:443", target: "/bot/path-secret", status: http.StatusMethodNotAllowed},
+		{name: "来源地址不在允许的网段内", security: security, remoteAddr: "1.2.3.4:443", target: "/bot/path-secret", status: http.StatusForbidden, metrics: WebhookMetrics{RejectedIP: 1}},
+		{name: "不受信任的来源伪造 X-Forwarded-For", security: security, remoteAddr: "1.2.3.4:443", target: "/bot/path-secret", header: map[string]string{"X-Forwarded-For": "149.154.160.1"}, status: http.StatusForbidden, metrics: WebhookMetrics{RejectedIP: 1}},
+		{name: "经受信任代理转发", security: security, remoteAddr: "10.0.0.1:443", target: "/bot/path-secret", header: map[string]string{"X-Forwarded-For": "149.154.160.1"}, status: http.StatusMethodNotAllowed},
+		{name: "X-Forwarded-For 最左侧伪造", security: security, remoteAddr: "10.0.0.1:443", target: "/bot/path-secret", header: map[string]string{"X-Forwarded-For": "149.154.160.1, 1.2.3.4"}, status: http.StatusForbidden, metrics: WebhookMetrics{RejectedIP: 1}},
+		{name: "X-Forwarded-For 无法解析", security: security, remoteAddr: "10.0.0.1:443", target: "/bot/path-secret", header: map[string]string{"X-Forwarded-For": "149.154.160.1, unknown"}, status: http.StatusForbidden, metrics: WebhookMetrics{RejectedIP: 1}},
+		{name: "使用 X-Real-IP", security: security, remoteAddr: "10.0.0.1:443", target: "/bot/path-secret", header: map[string]string{"X-Real-IP": "149.154.160.1"}, status: http.StatusMethodNotAllowed},
+		{name: "受信任代理本身不在允许的网段内", security: security, remoteAddr: "10.0.0.1:443", target: "/bot/path-secret", status: http.StatusForbidden, metrics: WebhookMetrics{RejectedIP: 1}},
+	}
+
+	f := newFakeTelegram(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := f.newBot(nil)
+			b.webhookSecretToken = test.token
+			b.SetWebhookSecurity(test.security)
+
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			request.RemoteAddr = test.remoteAddr
+			for k, v := range test.header {
+				request.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			b.WebhookHandler().ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("状态码 %d 与预期 %d 不一致", recorder.Code, test.status)
+			}
+			if metrics := b.WebhookMetrics(); metrics != test.metrics {
+				t.Fatalf("统计 %+v 与预期 %+v 不一致", metrics, test.metrics)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := ParseNetworks("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		want       string // 空字符串表示 nil
+	}{
+		{name: "直接连接", remoteAddr: "1.2.3.4:443", want: "1.2.3.4"},
+		{name: "没有端口", remoteAddr: "1.2.3.4", want: "1.2.3.4"},
+		{name: "不受信任的来源忽略请求头", remoteAddr: "1.2.3.4:443", header: map[string]string{"X-Forwarded-For": "5.6.7.8", "X-Real-IP": "5.6.7.8"}, want: "1.2.3.4"},
+		{name: "跳过受信任代理", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.2"}, want: "1.2.3.4"},
+		{name: "最左侧伪造", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Forwarded-For": "149.154.160.1, 5.6.7.8, 10.0.0.2"}, want: "5.6.7.8"},
+		{name: "全部为受信任代理", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "无法解析", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Forwarded-For": "1.2.3.4, unknown"}},
+		{name: "X-Forwarded-For 优先于 X-Real-IP", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "5.6.7.8"}, want: "1.2.3.4"},
+		{name: "X-Real-IP", remoteAddr: "10.0.0.1:443", header: map[string]string{"X-Real-IP": " 5.6.7.8 "}, want: "5.6.7.8"},
+		{name: "没有转发请求头", remoteAddr: "10.0.0.1:443", want: "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for k, v := range test.header {
+				request.Header.Set(k, v)
+			}
+			ip := clientIP(request, trusted)
+			if (test.want == "" && ip != nil) || (test.want != "" && !ip.Equal(net.ParseIP(test.want))) {
+				t.Fatalf("来源地址 %v 与预期 %q 不一致", ip, test.want)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	var result []string
+	for _, network := range ParseNetworks("1.2.3.4", "::1", "10.0.0.0/8", "unknown", "1.2.3.0/33") {
+		result = append(result, network.String())
+	}
+	if want := []string{"1.2.3.4/32", "::1/128", "10.0.0.0/8"}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("解析结果 %v 与预期 %v 不一致", result, want)
+	}
+
+	networks := TelegramNetworks()
+	for _, ip := range []string{"149.154.160.1", "149.154.175.254", "91.108.4.1", "91.108.7.254"} {
+		if !containsIP(networks, net.ParseIP(ip)) {
+			t.Fatalf("%s 应属于 telegram 网段", ip)
+		}
+	}
+	for _, ip := range []string{"149.154.176.1", "91.108.8.1", "1.2.3.4"} {
+		if containsIP(networks, net.ParseIP(ip)) {
+			t.Fatalf("%s 不应属于 telegram 网段", ip)
+		}
+	}
+}
+
+func TestBot_WebhookServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	f := newFakeTelegram(t)
+	b := f.newBot(nil)
+	got := make(chan string, 1)
+	b.SetMessageProcessor(func(c *Context) error {
+		got <- c.Message.Text
+		return nil
+	})
+	server := &http.Server{ReadTimeout: time.Second}
+	if err := b.SetWebhookWithServer("https://example.com/hook", address, nil, &WebhookServerOptional{Server: server, WriteTimeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- b.Run()
+	}()
+
+	body, _ := json.Marshal(textUpdate(1, &telegram.Chat{ID: 100}, nil, "hello"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := http.Post("http://"+address+"/hook", "application/json", bytes.NewReader(body))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("推送更新失败: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if result := collect(t, got, 1); result[0] != "hello" {
+		t.Fatalf("处理结果 %v 与预期不一致", result)
+	}
+	if server.Addr != "" || server.Handler != nil || server.WriteTimeout != 0 {
+		t.Fatal("不应修改调用者提供的 http.Server")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-runErr; err != ErrBotClosed {
+		t.Fatal(err)
+	}
+	if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("Shutdown 后监听仍未关闭")
+	}
+}